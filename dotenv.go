@@ -0,0 +1,206 @@
+// Copyright 2018 Netflix, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package env
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parse reads an EnvSet from r in the .env file format: one KEY=VALUE item per
+// line, optionally preceded by "export". Blank lines and lines starting with
+// "#" are skipped, and whitespace around keys and values is trimmed. A value
+// may be wrapped in double quotes, which are unquoted like a Go string
+// literal, or in single quotes, which are taken literally, so that it can hold
+// surrounding spaces or "#". A comment may follow a quoted value.
+//
+// Parse doesn't modify the process environment. A malformed line returns
+// ErrInvalidEnviron wrapped with its line number.
+func Parse(r io.Reader) (EnvSet, error) {
+	es := make(EnvSet)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := parseLine(line)
+		if !ok {
+			return nil, fmt.Errorf("line %d: %w", n, ErrInvalidEnviron)
+		}
+		es[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+// ParseFile reads an EnvSet from the .env file at path, as Parse does.
+func ParseFile(path string) (EnvSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// WriteTo writes es to w in the .env file format read by Parse, one KEY=VALUE
+// line per key in sorted order. Values holding whitespace, quotes, "=", "#" or
+// line breaks are double-quoted with strconv.Quote. It returns the number of
+// bytes written.
+func (es EnvSet) WriteTo(w io.Writer) (int64, error) {
+	return es.WriteWithOptions(w, WriteOptions{})
+}
+
+// WriteSections writes es to w as WriteTo does, but groups the keys into
+// sections by their first "_"-separated token, e.g. DB for DB_HOST and
+// DB_PORT. Each section starts with a "# [DB]" comment line and is separated
+// from the previous one by a blank line, so the output is still read by Parse.
+func (es EnvSet) WriteSections(w io.Writer) (int64, error) {
+	return es.WriteWithOptions(w, WriteOptions{Sections: true})
+}
+
+// WriteOptions configures the output of WriteWithOptions. The zero value
+// matches the output of WriteTo.
+type WriteOptions struct {
+	// CRLF ends lines with "\r\n" instead of "\n", for tools that expect
+	// Windows line endings. Parse reads either.
+	CRLF bool
+
+	// OmitTrailingNewline leaves out the line terminator after the last line,
+	// so the output ends with the last value.
+	OmitTrailingNewline bool
+
+	// Sections groups the keys into sections, as WriteSections does.
+	Sections bool
+}
+
+// WriteWithOptions writes es to w as WriteTo does, with the line terminators
+// and sections chosen by opts. It returns the number of bytes written.
+func (es EnvSet) WriteWithOptions(w io.Writer, opts WriteOptions) (int64, error) {
+	keys := make([]string, 0, len(es))
+	for k := range es {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	eol := "\n"
+	if opts.CRLF {
+		eol = "\r\n"
+	}
+
+	// each line but the first starts with the terminator of the one before, so
+	// that the last terminator can be left out
+	var written int64
+	var section string
+	for i, k := range keys {
+		var line string
+		if i > 0 {
+			line = eol
+		}
+		if s := sectionOf(k); opts.Sections && (i == 0 || s != section) {
+			if i > 0 {
+				line += eol
+			}
+			line += "# [" + s + "]" + eol
+			section = s
+		}
+
+		value := es[k]
+		if strings.ContainsAny(value, " \t\r\n\"'=#") {
+			value = strconv.Quote(value)
+		}
+		line += k + "=" + value
+		if i == len(keys)-1 && !opts.OmitTrailingNewline {
+			line += eol
+		}
+
+		n, err := io.WriteString(w, line)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// sectionOf returns the section of key, its part before the first "_", or the
+// whole key if it has none.
+func sectionOf(key string) string {
+	if i := strings.Index(key, "_"); i > 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// parseLine splits a trimmed, non-comment line into its key and value, and
+// reports whether the line is well formed.
+func parseLine(line string) (string, string, bool) {
+	line = strings.TrimPrefix(line, "export ")
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	key := strings.TrimSpace(parts[0])
+	if key == "" || strings.ContainsAny(key, " \t") {
+		return "", "", false
+	}
+
+	value := strings.TrimSpace(parts[1])
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		return key, value, true
+	}
+
+	// find the closing quote, skipping escaped quotes in double quotes
+	quote := value[0]
+	end := -1
+	for i := 1; i < len(value); i++ {
+		if quote == '"' && value[i] == '\\' {
+			i++
+			continue
+		}
+		if value[i] == quote {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return "", "", false
+	}
+
+	// only a comment may follow the closing quote
+	rest := strings.TrimSpace(value[end+1:])
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", "", false
+	}
+
+	if quote == '\'' {
+		return key, value[1:end], true
+	}
+	unquoted, err := strconv.Unquote(value[:end+1])
+	if err != nil {
+		return "", "", false
+	}
+	return key, unquoted, true
+}
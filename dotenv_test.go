@@ -0,0 +1,328 @@
+// Copyright 2018 Netflix, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package env
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	input := `# database
+DB_HOST=db.example.com
+  DB_PORT = 5432
+
+export DB_USER=admin
+DB_URL="postgres://admin@db/app?sslmode=disable" # primary
+GREETING="hello, \"world\"\n"
+PATTERN='a # b = c'
+EMPTY=
+`
+
+	es, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := EnvSet{
+		"DB_HOST":  "db.example.com",
+		"DB_PORT":  "5432",
+		"DB_USER":  "admin",
+		"DB_URL":   "postgres://admin@db/app?sslmode=disable",
+		"GREETING": "hello, \"world\"\n",
+		"PATTERN":  "a # b = c",
+		"EMPTY":    "",
+	}
+	if !reflect.DeepEqual(es, expected) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", expected, es)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for input, line := range map[string]string{
+		"HOME=/home/test\nINVALID\n":      "line 2",
+		"QUOTED=\"unterminated\n":         "line 1",
+		"\n\nQUOTED='value' trailing\n":   "line 3",
+		"=value\n":                        "line 1",
+		"HOME=/home/test\nMY KEY=value\n": "line 2",
+	} {
+		_, err := Parse(strings.NewReader(input))
+		if !errors.Is(err, ErrInvalidEnviron) {
+			t.Errorf("Expected error 'ErrInvalidEnviron' but got '%v'", err)
+		} else if !strings.HasPrefix(err.Error(), line+":") {
+			t.Errorf("Expected error for '%s' but got '%s'", line, err)
+		}
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	err := os.WriteFile(path, []byte("HOME=/home/test\nSLICE_STRING=\"a,b\"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Expected no error but got '%s'", err)
+	}
+
+	es, err := ParseFile(path)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	var validStruct ValidStruct
+	err = Unmarshal(es, &validStruct)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if validStruct.Home != "/home/test" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "/home/test", validStruct.Home)
+	}
+
+	stringSlice := []string{"a", "b"}
+	if !reflect.DeepEqual(validStruct.SliceString, stringSlice) {
+		t.Errorf("Expected field value to be '%s' but got '%s'", stringSlice, validStruct.SliceString)
+	}
+
+	_, err = ParseFile(filepath.Join(t.TempDir(), "missing.env"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected error 'os.ErrNotExist' but got '%v'", err)
+	}
+}
+
+type WriteStruct struct {
+	Home  string   `env:"HOME"`
+	Hosts []string `env:"HOSTS"`
+	Motd  string   `env:"MOTD"`
+	Quote string   `env:"QUOTE"`
+	Port  int      `env:"PORT"`
+	Debug bool     `env:"DEBUG"`
+}
+
+func TestWriteToRoundTrip(t *testing.T) {
+	writeStruct := WriteStruct{
+		Home:  "/home/my user",
+		Hosts: []string{"a b", "c=d"},
+		Motd:  "line1\nline2",
+		Quote: `'single' "double" \back`,
+		Port:  8080,
+		Debug: true,
+	}
+
+	es, err := Marshal(&writeStruct)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	var b strings.Builder
+	n, err := es.WriteTo(&b)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if n != int64(b.Len()) {
+		t.Errorf("Expected written count to be '%d' but got '%d'", b.Len(), n)
+	}
+
+	parsed, err := Parse(strings.NewReader(b.String()))
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	var roundTrip WriteStruct
+	err = Unmarshal(parsed, &roundTrip)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if !reflect.DeepEqual(roundTrip, writeStruct) {
+		t.Errorf("Expected field value to be '%v' but got '%v'", writeStruct, roundTrip)
+	}
+}
+
+func TestWriteToSorted(t *testing.T) {
+	es := EnvSet{
+		"PORT": "8080",
+		"HOST": "example.com",
+		"NAME": "my app",
+	}
+
+	var b strings.Builder
+	_, err := es.WriteTo(&b)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := "HOST=example.com\nNAME=\"my app\"\nPORT=8080\n"
+	if b.String() != expected {
+		t.Errorf("Expected output to be '%s' but got '%s'", expected, b.String())
+	}
+}
+
+func TestWriteToQuoting(t *testing.T) {
+	es := EnvSet{
+		"COMMENT": "a b#c",
+		"HASH":    "#fff",
+		"PLAIN":   "plain",
+	}
+
+	var b strings.Builder
+	_, err := es.WriteTo(&b)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := "COMMENT=\"a b#c\"\nHASH=\"#fff\"\nPLAIN=plain\n"
+	if b.String() != expected {
+		t.Errorf("Expected output to be '%s' but got '%s'", expected, b.String())
+	}
+
+	parsed, err := Parse(strings.NewReader(b.String()))
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if !reflect.DeepEqual(parsed, es) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", es, parsed)
+	}
+}
+
+func TestWriteToEmpty(t *testing.T) {
+	var b strings.Builder
+	n, err := EnvSet{}.WriteTo(&b)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if n != 0 || b.Len() != 0 {
+		t.Errorf("Expected nothing written but got '%d' bytes '%s'", n, b.String())
+	}
+}
+
+func TestWriteSections(t *testing.T) {
+	es := EnvSet{
+		"DB_HOST":    "localhost",
+		"DB_PORT":    "5432",
+		"PORT":       "8080",
+		"REDIS_URL":  "redis://cache",
+		"DBA_EMAIL":  "dba@example.com",
+		"REDIS_POOL": "10",
+	}
+
+	var b strings.Builder
+	n, err := es.WriteSections(&b)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := "# [DBA]\nDBA_EMAIL=dba@example.com\n" +
+		"\n# [DB]\nDB_HOST=localhost\nDB_PORT=5432\n" +
+		"\n# [PORT]\nPORT=8080\n" +
+		"\n# [REDIS]\nREDIS_POOL=10\nREDIS_URL=redis://cache\n"
+	if b.String() != expected {
+		t.Errorf("Expected output to be '%s' but got '%s'", expected, b.String())
+	}
+
+	if n != int64(len(expected)) {
+		t.Errorf("Expected '%d' bytes written but got '%d'", len(expected), n)
+	}
+
+	parsed, err := Parse(strings.NewReader(b.String()))
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if !reflect.DeepEqual(parsed, es) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", es, parsed)
+	}
+}
+
+func TestWriteWithOptions(t *testing.T) {
+	es := EnvSet{
+		"DB_HOST": "localhost",
+		"DB_PORT": "5432",
+		"NAME":    "my app",
+	}
+
+	tests := []struct {
+		opts     WriteOptions
+		expected string
+	}{
+		{
+			opts:     WriteOptions{},
+			expected: "DB_HOST=localhost\nDB_PORT=5432\nNAME=\"my app\"\n",
+		},
+		{
+			opts:     WriteOptions{CRLF: true},
+			expected: "DB_HOST=localhost\r\nDB_PORT=5432\r\nNAME=\"my app\"\r\n",
+		},
+		{
+			opts:     WriteOptions{OmitTrailingNewline: true},
+			expected: "DB_HOST=localhost\nDB_PORT=5432\nNAME=\"my app\"",
+		},
+		{
+			opts:     WriteOptions{CRLF: true, OmitTrailingNewline: true},
+			expected: "DB_HOST=localhost\r\nDB_PORT=5432\r\nNAME=\"my app\"",
+		},
+		{
+			opts: WriteOptions{CRLF: true, Sections: true},
+			expected: "# [DB]\r\nDB_HOST=localhost\r\nDB_PORT=5432\r\n" +
+				"\r\n# [NAME]\r\nNAME=\"my app\"\r\n",
+		},
+		{
+			opts: WriteOptions{Sections: true, OmitTrailingNewline: true},
+			expected: "# [DB]\nDB_HOST=localhost\nDB_PORT=5432\n" +
+				"\n# [NAME]\nNAME=\"my app\"",
+		},
+	}
+
+	for _, test := range tests {
+		var b strings.Builder
+		n, err := es.WriteWithOptions(&b, test.opts)
+		if err != nil {
+			t.Errorf("Expected no error but got '%s'", err)
+		}
+
+		if b.String() != test.expected {
+			t.Errorf("Expected output to be %q but got %q", test.expected, b.String())
+		}
+
+		if n != int64(len(test.expected)) {
+			t.Errorf("Expected '%d' bytes written but got '%d'", len(test.expected), n)
+		}
+
+		parsed, err := Parse(strings.NewReader(b.String()))
+		if err != nil {
+			t.Errorf("Expected no error but got '%s'", err)
+		}
+
+		if !reflect.DeepEqual(parsed, es) {
+			t.Errorf("Expected environ to be '%v' but got '%v'", es, parsed)
+		}
+	}
+}
+
+func TestWriteWithOptionsEmpty(t *testing.T) {
+	var b strings.Builder
+	n, err := EnvSet{}.WriteWithOptions(&b, WriteOptions{CRLF: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if n != 0 || b.Len() != 0 {
+		t.Errorf("Expected nothing written but got '%d' bytes '%s'", n, b.String())
+	}
+}
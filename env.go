@@ -17,12 +17,19 @@
 package env
 
 import (
+	"encoding"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"math"
+	"net/url"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -35,20 +42,263 @@ var (
 
 	// ErrUnexportedField returned when a field with tag "env" is not exported.
 	ErrUnexportedField = errors.New("field must be exported")
+
+	// ErrInvalidTag returned when a field tag "env" has malformed options.
+	ErrInvalidTag = errors.New("field tag is invalid")
+
+	// ErrEmptyKey returned when Options.ValidateKeys is set and the EnvSet
+	// contains the empty key.
+	ErrEmptyKey = errors.New("environment variable key must not be empty")
+
+	// ErrMissingRequiredValue returned when a field with the "required" tag
+	// option has no matching key in the EnvSet. It is wrapped with the name of
+	// the missing key.
+	ErrMissingRequiredValue = errors.New("required value is missing")
+
+	// ErrUnresolvedReference returned when Options.ExpandStrict is set and a
+	// value references a key that isn't in the EnvSet. It is wrapped with the
+	// name of the missing key.
+	ErrUnresolvedReference = errors.New("referenced value is missing")
+
+	// ErrCyclicReference returned when Options.Expand is set and a value
+	// references itself, directly or through other keys. It is wrapped with
+	// the name of the key that closes the cycle.
+	ErrCyclicReference = errors.New("reference is cyclic")
+
+	// ErrAmbiguousKey returned when Options.CaseInsensitive is set and the
+	// EnvSet holds several keys matching a field's key that differ only by
+	// case, none of them exactly. It is wrapped with the field's key.
+	ErrAmbiguousKey = errors.New("key matches several keys differing by case")
+
+	// ErrMissingSign returned when a field with the "requireSign" tag option
+	// has a value that doesn't start with "+" or "-".
+	ErrMissingSign = errors.New("value must start with a sign")
+
+	// ErrValueTooLong returned when a value is longer than
+	// Options.MaxValueLen. It is wrapped with the key of the value.
+	ErrValueTooLong = errors.New("value exceeds the maximum length")
+
+	// ErrUnknownKey returned when Options.StrictPrefix is set and a key with
+	// that prefix isn't matched by any field. It is wrapped with the key.
+	ErrUnknownKey = errors.New("key doesn't match any field")
+
+	// ErrInexactDuration returned when marshalling a time.Duration with the
+	// "durationAs" tag option that isn't a whole number of the unit, e.g.
+	// 1500ms as seconds. It is wrapped with the key of the value.
+	ErrInexactDuration = errors.New("duration isn't a whole number of the unit")
+)
+
+// Unmarshaler is the interface implemented by types that can unmarshal an
+// environment variable value of themselves. UnmarshalEnv receives the raw
+// value, which may be the empty string.
+type Unmarshaler interface {
+	UnmarshalEnv(value string) error
+}
+
+// Marshaler is the interface implemented by types that can marshal themselves
+// into an environment variable value.
+type Marshaler interface {
+	MarshalEnv() (string, error)
+}
+
+// Validator is the interface implemented by structs that check their own
+// fields once they have been unmarshalled.
+type Validator interface {
+	Validate() error
+}
+
+// FieldError describes a failure to unmarshal the value of a single key.
+type FieldError struct {
+	Key string
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return e.Key + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+	urlType      = reflect.TypeOf(url.URL{})
+	bytesType    = reflect.TypeOf([]byte(nil))
 )
 
 // Unmarshal parses an EnvSet and stores the result in the value pointed to by
 // v. Fields that are matched in v will be deleted from EnvSet, resulting in
-// an EnvSet with the remaining environment variables. If v is nil or not a
-// pointer to a struct, Unmarshal returns an ErrInvalidValue.
+// an EnvSet with the remaining environment variables; pass es.Clone() to keep
+// es intact. If v is nil or not a pointer to a struct, Unmarshal returns an
+// ErrInvalidValue.
 //
 // Fields tagged with "env" will have the unmarshalled EnvSet of the matching
 // key from EnvSet. If the tagged field is not exported, Unmarshal returns
-// ErrUnexportedField.
+// ErrUnexportedField. Fields tagged `env:"-"` are ignored.
+//
+// A field with the "json" tag option, e.g. `env:"LIMITS,json"`, is parsed with
+// json.Unmarshal whatever its type, and a decoding error is returned in a
+// *FieldError naming the key. A []byte field is decoded from standard base64,
+// or from the encoding given by the "encoding" tag option, e.g.
+// `env:"KEY,encoding=base64url"` for URL-safe base64, or "base64raw" and
+// "base64rawurl" for their unpadded forms.
+//
+// Otherwise, a field implementing Unmarshaler is parsed with UnmarshalEnv. A
+// time.Time field is parsed with the layout given by the "layout" tag option,
+// e.g. `env:"BUILD_DATE,layout=2006-01-02"`, or with the first that matches of
+// the layouts given by the "layouts" tag option, e.g.
+// `env:"TS,layouts=RFC3339|2006-01-02"`, or time.RFC3339 by default; a layout
+// may be the name of a time package constant such as RFC1123. A url.URL field,
+// or pointer to one, is parsed with url.Parse. A field implementing
+// encoding.TextUnmarshaler is parsed with UnmarshalText, or else one
+// implementing flag.Value is parsed with Set. A time.Duration field is parsed
+// with time.ParseDuration, or as an integer count of seconds or milliseconds
+// with the "durationAs" tag option, e.g. `env:"TTL,durationAs=s"` or
+// `durationAs=ms`.
+//
+// Remaining fields are parsed according to their kind. Booleans accept the
+// words given by the "true" and "false" tag options in place of the forms
+// accepted by strconv.ParseBool, e.g. `env:"FLAG,true=enabled,false=disabled"`.
+// Integers of any size are parsed in base 10, or in the base given by the
+// "base" tag option, e.g. `env:"MASK,base=16"`; "base=0" detects the base from
+// a 0x, 0o or 0b prefix, or a leading 0 for octal, as strconv.ParseInt does. A
+// signed int field with the "requireSign" tag option, e.g.
+// `env:"DELTA,requireSign"`, returns ErrMissingSign unless its value starts
+// with "+" or "-".
+//
+// Slices are split on commas, or on the value of the "separator" tag option,
+// e.g. `env:"HOSTS,separator=;"`, which may be several characters long, e.g.
+// `env:"HOSTS,separator= | "`, but can't contain a comma. An empty or blank
+// value is parsed as an empty slice. Arrays are split the same way and must
+// have exactly as many elements as the array's length. Maps with string, bool
+// or integer keys and string, int, bool or time.Duration values are parsed from
+// the same list of "key=value" pairs, e.g. `LABELS=env=prod,team=core`, with
+// each key parsed like a slice element and each value parsed like a field of
+// its type.
+//
+// A field with the "trim" tag option, e.g. `env:"NAME,trim"`, has leading and
+// trailing whitespace removed from its value, and from each slice element and
+// map key and value, as Options.TrimSpace does for values other than strings.
+//
+// A slice field with the "indexed" tag option, e.g. `env:"ARG,indexed"`, is
+// instead collected from the keys ARG_1, ARG_2 and so on, up to the first
+// missing index, with each value parsed as one element.
+//
+// Alias keys may follow the key in the tag, e.g. `env:"CACHE_URL,REDIS_URL"`,
+// and are tried in order if the key is missing; the first one present is used
+// and deleted from EnvSet. Any item without "=" that isn't a known flag, such
+// as "required", is an alias, unless it's spelled like a flag, e.g. a
+// misspelled "requird", or empty, which returns ErrInvalidTag.
+//
+// If no key is present in EnvSet, the value of the "default" tag option is
+// parsed instead, e.g. `env:"PORT,default=8080"`. A default can also be taken
+// conditionally on another environment variable with the "defaultIf" tag
+// option, e.g. `env:"DEBUG,defaultIf=ENV==development:true"`, which wins over
+// "default" when its condition holds. A string field can instead be assembled
+// from other keys with the "concat" tag option, e.g.
+// `env:"DSN,concat=DB_USER:DB_PASS@DB_HOST"`; a referenced key that is missing
+// is an error wrapping ErrMissingRequiredValue unless "concatMissing=empty" is
+// also given.
+//
+// A field with the "required" tag option, e.g. `env:"DATABASE_URL,required"`,
+// and no key present in EnvSet returns an error wrapping
+// ErrMissingRequiredValue that names the key. A key set to the empty string is
+// present.
+//
+// Nested structs, and pointers to structs, are traversed recursively, unless
+// they have a key and are parsed as a single value, with the "json" tag option
+// or by implementing Unmarshaler, encoding.TextUnmarshaler or flag.Value. Any
+// other struct field with a key returns ErrInvalidTag. A nil pointer to a
+// struct is allocated only if any of its fields are set. A nested struct field
+// with the "prefix" tag option, e.g. `env:"DB_,prefix"`, prepends its key to
+// the keys of its fields, composing across levels of nesting. Embedded structs
+// are traversed the same way, without needing a tag, even if their type is
+// unexported.
+//
+// Once its fields are set, a struct implementing Validator is checked with
+// Validate, and its error is returned. Nested structs are validated before
+// the struct containing them, and a nil pointer to a struct that is left
+// unallocated is not validated.
+//
+// A malformed tag option, or a "required" field that also declares a default,
+// returns an error wrapping ErrInvalidTag.
 //
 // If the field has a type that is unsupported, Unmarshal returns
 // ErrUnsupportedType.
 func Unmarshal(es EnvSet, v interface{}) error {
+	return UnmarshalWithOptions(es, v, Options{})
+}
+
+// UnmarshalWithOptions behaves like Unmarshal, with its behavior adjusted by
+// opts. Unmarshal is equivalent to UnmarshalWithOptions with zero-value
+// Options.
+func UnmarshalWithOptions(es EnvSet, v interface{}, opts Options) error {
+	d := decoder{es: es, opts: opts}
+	err := d.unmarshal(v)
+	if err != nil {
+		return err
+	}
+	return errors.Join(d.errs...)
+}
+
+// UnmarshalAll behaves like Unmarshal, but rather than stopping at the first
+// field that fails to unmarshal it attempts every field and returns the
+// failures joined with errors.Join. Each joined error is a *FieldError naming
+// the key of its field. Fields that unmarshal successfully are still set.
+func UnmarshalAll(es EnvSet, v interface{}) error {
+	return UnmarshalWithOptions(es, v, Options{CollectErrors: true})
+}
+
+// Validate reports whether es can be unmarshalled into v, without modifying
+// either: v is only used for its type. Like UnmarshalAll, it attempts every
+// field and returns the failures joined with errors.Join.
+func Validate(es EnvSet, v interface{}) error {
+	return ValidateWithOptions(es, v, Options{})
+}
+
+// ValidateWithOptions behaves like Validate, reporting whether es can be
+// unmarshalled into v by UnmarshalWithOptions with opts. Every field is
+// attempted, as if Options.CollectErrors were set.
+func ValidateWithOptions(es EnvSet, v interface{}, opts Options) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrInvalidValue
+	}
+
+	opts.CollectErrors = true
+	d := decoder{es: es.Clone(), opts: opts}
+	err := d.unmarshal(reflect.New(rv.Elem().Type()).Interface())
+	if err != nil {
+		return err
+	}
+	return errors.Join(d.errs...)
+}
+
+// decoder holds the state of a single call to unmarshal an EnvSet.
+type decoder struct {
+	es   EnvSet
+	opts Options
+
+	// source holds the keys of es before unmarshalling consumes them, for
+	// expanding references and evaluating the "defaultIf" and "concat" tag
+	// options, which may refer to keys of fields already unmarshalled.
+	source EnvSet
+
+	// errs holds the field errors collected if Options.CollectErrors is set.
+	errs []error
+
+	// allocating holds the types of nil struct pointers being unmarshalled.
+	allocating map[reflect.Type]bool
+
+	// assigned counts the fields set so far, from a key or a default, to tell
+	// whether a nil struct pointer must be allocated.
+	assigned int
+}
+
+func (d *decoder) unmarshal(v interface{}) error {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
 		return ErrInvalidValue
@@ -59,100 +309,777 @@ func Unmarshal(es EnvSet, v interface{}) error {
 		return ErrInvalidValue
 	}
 
+	if d.opts.ValidateKeys {
+		if _, ok := d.es[""]; ok {
+			return ErrEmptyKey
+		}
+	}
+
+	d.source = d.es.Clone()
+
+	err := d.decode(rv, "")
+	if err != nil {
+		return err
+	}
+	err = d.validate(rv)
+	if err != nil {
+		return err
+	}
+	return d.checkUnknown()
+}
+
+// checkUnknown returns ErrUnknownKey for every key left in es with the prefix
+// Options.StrictPrefix, if set, in key order.
+func (d *decoder) checkUnknown() error {
+	if d.opts.StrictPrefix == "" {
+		return nil
+	}
+
+	var keys []string
+	for k := range d.es {
+		if strings.HasPrefix(k, d.opts.StrictPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	errs := make([]error, len(keys))
+	for i, k := range keys {
+		errs[i] = &FieldError{Key: k, Err: ErrUnknownKey}
+	}
+	if d.opts.CollectErrors {
+		d.errs = append(d.errs, errs...)
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// decode unmarshals into the fields of the struct rv, prepending prefix to
+// every key.
+func (d *decoder) decode(rv reflect.Value, prefix string) error {
 	t := rv.Type()
+	tags := tagsOf(t, d.opts)
 	for i := 0; i < rv.NumField(); i++ {
 		valueField := rv.Field(i)
-		switch valueField.Kind() {
-		case reflect.Struct:
-			if !valueField.Addr().CanInterface() {
+		typeField := t.Field(i)
+		tag := tags[i]
+		if tag.skip() {
+			d.logf("skipping field %s tagged %q", typeField.Name, "-")
+			continue
+		}
+
+		if _, ok := tag.option("prefix"); ok {
+			tag.key = prefix + tag.key
+			if !isStruct(typeField.Type) {
+				err := d.fieldError(tag.key, tag.invalid())
+				if err != nil {
+					return err
+				}
 				continue
 			}
 
-			iface := valueField.Addr().Interface()
-			err := Unmarshal(es, iface)
-			if err != nil {
-				return err
-			}
-		}
+			if !isTraversable(typeField) {
+				continue
+			}
+			err := d.decodeStruct(valueField, tag.key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if isStruct(typeField.Type) && isTraversable(typeField) && !isValue(typeField.Type, tag) {
+			// a key on a struct that can't be parsed as a single value is
+			// meaningless; nest it with the "prefix" tag option instead
+			if tag.key != "" {
+				tag = tag.withPrefix(prefix)
+				err := d.fieldError(tag.key, tag.invalid())
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			err := d.decodeStruct(valueField, prefix)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if tag.key == "" {
+			continue
+		}
+		tag = tag.withPrefix(prefix)
+
+		err := d.fieldError(tag.key, d.decodeField(typeField, valueField, tag))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeIndexed unmarshals the slice f from the keys KEY_1, KEY_2 and so on,
+// stopping at the first missing index.
+func (d *decoder) decodeIndexed(t reflect.Type, f reflect.Value, tag fieldTag) error {
+	var keys []string
+	for i := 1; ; i++ {
+		key, ok, err := d.lookup(tag.key + "_" + strconv.Itoa(i))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		if _, required := tag.option("required"); required {
+			return &FieldError{Key: tag.key + "_1", Err: ErrMissingRequiredValue}
+		}
+		return nil
+	}
+
+	v := reflect.MakeSlice(t, len(keys), len(keys))
+	for i, key := range keys {
+		value, err := d.unescape(key, d.es[key])
+		if err != nil {
+			return err
+		}
+		value, err = d.expand(key, value)
+		if err != nil {
+			return &FieldError{Key: key, Err: err}
+		}
+		err = d.checkLen(key, value)
+		if err != nil {
+			return err
+		}
+		err = set(t.Elem(), v.Index(i), value, tag, d.opts)
+		if err != nil {
+			return &FieldError{Key: key, Err: err}
+		}
+	}
+	if d.opts.AppendSlices {
+		v = reflect.AppendSlice(f, v)
+	}
+	f.Set(v)
+	d.assigned += len(keys)
+
+	for _, key := range keys {
+		delete(d.es, key)
+	}
+	return nil
+}
+
+// decodeStruct unmarshals into the struct, or pointer to struct, f. A nil
+// pointer is allocated only if unmarshalling sets any of its fields.
+func (d *decoder) decodeStruct(f reflect.Value, prefix string) error {
+	if f.Kind() != reflect.Ptr {
+		err := d.decode(f, prefix)
+		if err != nil {
+			return err
+		}
+		return d.validate(f)
+	}
+
+	if !f.IsNil() {
+		return d.decodeStruct(f.Elem(), prefix)
+	}
+
+	// guard against recursive types, which would otherwise be allocated
+	// forever
+	t := f.Type().Elem()
+	if !f.CanSet() || d.allocating[t] {
+		return nil
+	}
+	if d.allocating == nil {
+		d.allocating = make(map[reflect.Type]bool)
+	}
+	d.allocating[t] = true
+	defer delete(d.allocating, t)
+
+	ptr := reflect.New(t)
+	assigned := d.assigned
+	err := d.decode(ptr.Elem(), prefix)
+	if err != nil {
+		return err
+	}
+	if d.assigned == assigned {
+		return nil
+	}
+	f.Set(ptr)
+	return d.validate(ptr.Elem())
+}
+
+// validate calls Validate on the struct rv if it implements Validator. The
+// error is collected and nil returned if Options.CollectErrors is set.
+func (d *decoder) validate(rv reflect.Value) error {
+	if !rv.CanAddr() || !rv.Addr().CanInterface() {
+		return nil
+	}
+	v, ok := rv.Addr().Interface().(Validator)
+	if !ok {
+		return nil
+	}
+
+	err := v.Validate()
+	if err != nil && d.opts.CollectErrors {
+		d.errs = append(d.errs, err)
+		return nil
+	}
+	return err
+}
+
+// fieldError returns err, or collects it and returns nil if
+// Options.CollectErrors is set.
+func (d *decoder) fieldError(key string, err error) error {
+	if err == nil || !d.opts.CollectErrors {
+		return err
+	}
+	if _, ok := err.(*FieldError); !ok {
+		err = &FieldError{Key: key, Err: err}
+	}
+	d.errs = append(d.errs, err)
+	return nil
+}
+
+func (d *decoder) decodeField(typeField reflect.StructField, valueField reflect.Value, tag fieldTag) error {
+	if !valueField.CanSet() {
+		return ErrUnexportedField
+	}
+
+	err := tag.validate(typeField.Type)
+	if err != nil {
+		return err
+	}
+
+	err = d.checkDefaults(typeField.Type, tag)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := tag.option("indexed"); ok {
+		return d.decodeIndexed(typeField.Type, valueField, tag)
+	}
+
+	var key string
+	var found bool
+	for _, k := range tag.keys() {
+		key, found, err = d.lookup(k)
+		if err != nil {
+			return err
+		}
+		if found {
+			if k != tag.key {
+				d.logf("%s: missing, using alias %s for field %s", tag.key, k, typeField.Name)
+			}
+			break
+		}
+	}
+
+	var envVar string
+	if found {
+		envVar, err = d.unescape(key, d.es[key])
+		if err != nil {
+			return err
+		}
+	} else {
+		key = tag.key
+		var ok bool
+		envVar, ok, err = tag.defaultValue(d.source)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if _, required := tag.option("required"); required {
+				return &FieldError{Key: tag.key, Err: ErrMissingRequiredValue}
+			}
+			d.logf("%s: missing, leaving field %s unset", tag.key, typeField.Name)
+			return nil
+		}
+		d.logf("%s: missing, using default %q for field %s", tag.key, envVar, typeField.Name)
+	}
+
+	envVar, err = d.expand(tag.key, envVar)
+	if err != nil {
+		return err
+	}
+
+	err = d.checkLen(key, envVar)
+	if err != nil {
+		return err
+	}
+
+	err = set(typeField.Type, valueField, envVar, tag, d.opts)
+	if err != nil {
+		return err
+	}
+	d.assigned++
+	// only the key the value came from is consumed, not one a default was
+	// used in place of
+	if found {
+		delete(d.es, key)
+	}
+	return nil
+}
+
+// lookup returns the key of es that matches key, and whether there is one. If
+// Options.CaseInsensitive is set, a key that matches exactly is preferred over
+// one that differs only by case, and several keys differing only by case
+// return ErrAmbiguousKey.
+func (d *decoder) lookup(key string) (string, bool, error) {
+	if _, ok := d.es[key]; ok || !d.opts.CaseInsensitive {
+		return key, ok, nil
+	}
+
+	var match string
+	var found bool
+	for k := range d.es {
+		if !strings.EqualFold(k, key) {
+			continue
+		}
+		if found {
+			return "", false, &FieldError{Key: key, Err: ErrAmbiguousKey}
+		}
+		match, found = k, true
+	}
+	if found {
+		d.logf("%s: using key %s, which differs by case", key, match)
+	}
+	return match, found, nil
+}
+
+// checkDefaults parses every default declared by tag into a scratch value of
+// type t, if Options.ValidateDefaults is set, so that a default that can't be
+// parsed is reported even if the key is present.
+func (d *decoder) checkDefaults(t reflect.Type, tag fieldTag) error {
+	if !d.opts.ValidateDefaults {
+		return nil
+	}
+
+	for _, v := range tag.defaults() {
+		value, err := d.expand(tag.key, v)
+		if err != nil {
+			return err
+		}
+		err = set(t, reflect.New(t).Elem(), value, tag, d.opts)
+		if err != nil {
+			return &FieldError{Key: tag.key, Err: fmt.Errorf("default %q: %w", value, err)}
+		}
+	}
+	return nil
+}
+
+// unescape decodes the percent-encoding of value with url.QueryUnescape, if
+// Options.URLDecodeValues is set.
+func (d *decoder) unescape(key, value string) (string, error) {
+	if !d.opts.URLDecodeValues {
+		return value, nil
+	}
+	unescaped, err := url.QueryUnescape(value)
+	if err != nil {
+		return "", &FieldError{Key: key, Err: err}
+	}
+	return unescaped, nil
+}
+
+// checkLen returns ErrValueTooLong if value is longer than
+// Options.MaxValueLen bytes, if set.
+func (d *decoder) checkLen(key, value string) error {
+	if d.opts.MaxValueLen > 0 && len(value) > d.opts.MaxValueLen {
+		return &FieldError{Key: key, Err: ErrValueTooLong}
+	}
+	return nil
+}
+
+// logf reports a diagnostic to Options.Logger, if set.
+func (d *decoder) logf(format string, args ...interface{}) {
+	if d.opts.Logger != nil {
+		d.opts.Logger(format, args...)
+	}
+}
+
+// expand replaces the ${VAR} and $VAR references in the value of key with the
+// values of those keys, expanded in turn, if Options.Expand is set. "$$" is
+// replaced with a literal "$".
+func (d *decoder) expand(key, value string) (string, error) {
+	if !d.opts.Expand {
+		return value, nil
+	}
+	return d.expandRefs(value, map[string]bool{key: true})
+}
+
+// expandRefs expands value, where expanding holds the keys whose values are
+// being expanded, to detect cycles.
+func (d *decoder) expandRefs(value string, expanding map[string]bool) (string, error) {
+	var err error
+	expanded := os.Expand(value, func(name string) string {
+		if err != nil {
+			return ""
+		}
+		if name == "$" {
+			return "$"
+		}
+		if expanding[name] {
+			err = fmt.Errorf("%s: %w", name, ErrCyclicReference)
+			return ""
+		}
+
+		v, ok := d.source[name]
+		if !ok {
+			if d.opts.ExpandStrict {
+				err = fmt.Errorf("%s: %w", name, ErrUnresolvedReference)
+			}
+			return ""
+		}
+
+		expanding[name] = true
+		v, err = d.expandRefs(v, expanding)
+		delete(expanding, name)
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+func set(t reflect.Type, f reflect.Value, value string, tag fieldTag, opts Options) error {
+	if trimSpace(t, tag, opts) {
+		value = strings.TrimSpace(value)
+	}
+
+	if _, ok := tag.option("json"); ok {
+		err := json.Unmarshal([]byte(value), f.Addr().Interface())
+		if err != nil {
+			return &FieldError{Key: tag.key, Err: err}
+		}
+		return nil
+	}
+
+	// a pointer to []byte is decoded through its element, below
+	if _, ok := tag.option("encoding"); ok && t.Kind() != reflect.Ptr || t == bytesType {
+		enc, known := tag.encoding()
+		if !known || t != bytesType {
+			return tag.invalid()
+		}
+		b, err := enc.DecodeString(value)
+		if err != nil {
+			return err
+		}
+		f.SetBytes(b)
+		return nil
+	}
+
+	if f.CanAddr() {
+		if u, ok := f.Addr().Interface().(Unmarshaler); ok {
+			return u.UnmarshalEnv(value)
+		}
+	}
+
+	if t == timeType {
+		layouts := tag.layouts()
+		var firstErr error
+		for _, layout := range layouts {
+			v, err := time.Parse(layout, value)
+			if err == nil {
+				f.Set(reflect.ValueOf(v))
+				return nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if len(layouts) == 1 {
+			return firstErr
+		}
+		return fmt.Errorf("time %q matches none of the layouts %q: %w", value, layouts, firstErr)
+	}
+
+	if t == urlType {
+		v, err := url.Parse(value)
+		if err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(*v))
+		return nil
+	}
+
+	// time.Duration is an int64 underneath, but is expressed as "30s" rather
+	// than a count of nanoseconds.
+	if t == durationType {
+		if unit, ok := tag.durationUnit(); ok {
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			if v > math.MaxInt64/int64(unit) || v < math.MinInt64/int64(unit) {
+				return &strconv.NumError{Func: "ParseInt", Num: value, Err: strconv.ErrRange}
+			}
+			f.SetInt(v * int64(unit))
+			return nil
+		}
+
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(v))
+		return nil
+	}
+
+	if f.CanAddr() {
+		if u, ok := f.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(value))
+		}
+		if v, ok := f.Addr().Interface().(flag.Value); ok {
+			return v.Set(value)
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		ptr := reflect.New(t.Elem())
+		err := set(t.Elem(), ptr.Elem(), value, tag, opts)
+		if err != nil {
+			return err
+		}
+		f.Set(ptr)
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		v, err := parseBool(value, tag, opts)
+		if err != nil {
+			return err
+		}
+		f.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, ok := tag.option("requireSign"); ok && !strings.HasPrefix(value, "+") && !strings.HasPrefix(value, "-") {
+			return &strconv.NumError{Func: "ParseInt", Num: value, Err: ErrMissingSign}
+		}
+		base, _ := tag.base()
+		v, err := strconv.ParseInt(value, base, t.Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		base, _ := tag.base()
+		v, err := strconv.ParseUint(value, base, t.Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(value, t.Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(v)
+	case reflect.Slice:
+		var v reflect.Value
+		if trimmed := strings.TrimSpace(value); opts.AutoSlice && strings.HasPrefix(trimmed, "[") {
+			// parse a JSON array, e.g. ["a","b"], as a whole
+			ptr := reflect.New(t)
+			if err := json.Unmarshal([]byte(trimmed), ptr.Interface()); err != nil {
+				return err
+			}
+			v = ptr.Elem()
+		} else {
+			a := splitList(t.Elem(), value, tag, opts)
+
+			// create slice based on for defined type
+			v = reflect.MakeSlice(t, len(a), len(a))
+			if err := setElements(t.Elem(), v, a, tag, opts); err != nil {
+				return err
+			}
+		}
+
+		// set value, keeping any existing elements if requested
+		if opts.AppendSlices {
+			v = reflect.AppendSlice(f, v)
+		}
+		f.Set(v)
+
+	case reflect.Array:
+		a := splitList(t.Elem(), value, tag, opts)
+		if len(a) != t.Len() {
+			return fmt.Errorf("array must have %d elements but got %d", t.Len(), len(a))
+		}
+
+		v := reflect.New(t).Elem()
+		if err := setElements(t.Elem(), v, a, tag, opts); err != nil {
+			return err
+		}
+		f.Set(v)
+
+	case reflect.Map:
+		if !isMapKey(t.Key()) {
+			return ErrUnsupportedType
+		}
+		switch t.Elem().Kind() {
+		case reflect.String, reflect.Int, reflect.Bool:
+		default:
+			if t.Elem() != durationType {
+				return ErrUnsupportedType
+			}
+		}
+
+		// split the environment variable string into key=value pairs, where
+		// later duplicate keys override earlier ones
+		v := reflect.MakeMap(t)
+		if strings.TrimSpace(value) != "" {
+			for _, pair := range strings.Split(value, tag.separator()) {
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 {
+					return fmt.Errorf("map entry %q must have format key=value", pair)
+				}
+
+				// the value is trimmed by set, as for a field
+				if trimSpace(t.Key(), tag, opts) {
+					kv[0] = strings.TrimSpace(kv[0])
+				}
+
+				key := reflect.New(t.Key()).Elem()
+				err := setElement(t.Key(), key, kv[0], tag, opts)
+				if err != nil {
+					return fmt.Errorf("map key %q: %w", kv[0], err)
+				}
+
+				element := reflect.New(t.Elem()).Elem()
+				err = set(t.Elem(), element, kv[1], tag, opts)
+				if err != nil {
+					return fmt.Errorf("map entry %q: %w", kv[0], err)
+				}
+				v.SetMapIndex(key, element)
+			}
+		}
+		f.Set(v)
+
+	default:
+		return ErrUnsupportedType
+	}
+	return nil
+}
+
+// trimSpace reports whether a value, list element or map key or value of type
+// t is trimmed of surrounding whitespace, by the "trim" tag option or, unless
+// t is a string, by Options.TrimSpace.
+func trimSpace(t reflect.Type, tag fieldTag, opts Options) bool {
+	_, ok := tag.option("trim")
+	return ok || opts.TrimSpace && !isString(t)
+}
 
-		typeField := t.Field(i)
-		tag := typeField.Tag.Get("env")
-		if tag == "" {
-			continue
-		}
+// isString reports whether t is a string, or a pointer to one, or a slice,
+// array or map holding strings, whose surrounding whitespace is only trimmed on
+// request by the "trim" tag option. The whitespace around a list or map value
+// belongs to its first and last strings, so it isn't trimmed as a whole.
+func isString(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return true
+	case reflect.Slice, reflect.Array:
+		return isString(t.Elem())
+	case reflect.Map:
+		return isString(t.Key()) || isString(t.Elem())
+	}
+	return false
+}
 
-		if !valueField.CanSet() {
-			return ErrUnexportedField
+// splitList splits the environment variable string into list elements of type
+// t, where an empty or blank string has no elements rather than one empty
+// element.
+func splitList(t reflect.Type, value string, tag fieldTag, opts Options) []string {
+	var a []string
+	if strings.TrimSpace(value) != "" {
+		a = strings.Split(value, tag.separator())
+	}
+
+	if trimSpace(t, tag, opts) {
+		for i, element := range a {
+			a[i] = strings.TrimSpace(element)
 		}
+	}
 
-		envVar, ok := es[tag]
-		if !ok {
-			continue
+	// drop empty elements, e.g. from "a,,b", if requested
+	if opts.CollapseEmpty {
+		b := a[:0]
+		for _, element := range a {
+			if element != "" {
+				b = append(b, element)
+			}
 		}
+		a = b
+	}
+	return a
+}
 
-		err := set(typeField.Type, valueField, envVar)
+// setElements parses each element of a to the element type t and stores it
+// at the same index of the slice or array v.
+func setElements(t reflect.Type, v reflect.Value, a []string, tag fieldTag, opts Options) error {
+	for index, element := range a {
+		err := setElement(t, v.Index(index), element, tag, opts)
 		if err != nil {
 			return err
 		}
-		delete(es, tag)
 	}
-
 	return nil
 }
 
-func set(t reflect.Type, f reflect.Value, value string) error {
-	switch t.Kind() {
-	case reflect.Ptr:
-		ptr := reflect.New(t.Elem())
-		err := set(t.Elem(), ptr.Elem(), value)
+// setElement parses a single slice or array element to the type t and stores
+// it in f. Pointer elements, e.g. of []*int, are allocated.
+func setElement(t reflect.Type, f reflect.Value, element string, tag fieldTag, opts Options) error {
+	if t == durationType {
+		elementDuration, err := time.ParseDuration(element)
 		if err != nil {
 			return err
 		}
-		f.Set(ptr)
+		f.SetInt(int64(elementDuration))
+		return nil
+	}
+
+	switch t.Kind() {
 	case reflect.String:
-		f.SetString(value)
-	case reflect.Bool:
-		v, err := strconv.ParseBool(value)
+		f.SetString(element)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		base, _ := tag.base()
+		elementInt, err := strconv.ParseInt(element, base, t.Bits())
 		if err != nil {
 			return err
 		}
-		f.SetBool(v)
-	case reflect.Int:
-		v, err := strconv.Atoi(value)
+		f.SetInt(elementInt)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		base, _ := tag.base()
+		elementUint, err := strconv.ParseUint(element, base, t.Bits())
 		if err != nil {
 			return err
 		}
-		f.SetInt(int64(v))
-	case reflect.Slice:
-		// split the environment variable string and check if it is not empty
-		a := strings.Split(value, ",")
-		if len(a) == 0 {
-			return ErrUnsupportedType
+		f.SetUint(elementUint)
+	case reflect.Bool:
+		elementBool, err := parseBool(element, tag, opts)
+		if err != nil {
+			return err
 		}
-
-		// create slice based on for defined type
-		v := reflect.MakeSlice(t, len(a), len(a))
-
-		// loop through input, parse to required type and add to the slice
-		elementType := t.Elem().Kind()
-		for index, element := range a {
-			switch elementType {
-				case reflect.String:
-					v.Index(index).Set(reflect.ValueOf(element))
-				case reflect.Int:
-					elementInt, err := strconv.Atoi(element)
-					if err != nil {
-						return ErrUnsupportedType
-					}
-					v.Index(index).SetInt(int64(elementInt))
-				default:
-					return ErrUnsupportedType
-			}
+		f.SetBool(elementBool)
+	case reflect.Float32, reflect.Float64:
+		elementFloat, err := strconv.ParseFloat(element, t.Bits())
+		if err != nil {
+			return err
 		}
-
-		// set value
-		f.Set(v)
-
+		f.SetFloat(elementFloat)
+	case reflect.Ptr:
+		ptr := reflect.New(t.Elem())
+		err := setElement(t.Elem(), ptr.Elem(), element, tag, opts)
+		if err != nil {
+			return err
+		}
+		f.Set(ptr)
 	default:
 		return ErrUnsupportedType
 	}
@@ -179,14 +1106,68 @@ func UnmarshalFromEnviron(v interface{}) (EnvSet, error) {
 	return es, Unmarshal(es, v)
 }
 
+// UnmarshalFromEnvironWithOptions behaves like UnmarshalFromEnviron, with its
+// behavior adjusted by opts.
+func UnmarshalFromEnvironWithOptions(v interface{}, opts Options) (EnvSet, error) {
+	es, err := EnvironToEnvSet(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	return es, UnmarshalWithOptions(es, v, opts)
+}
+
 // Marshal returns an EnvSet of v. If v is nil or not a pointer, Marshal returns
 // an ErrInvalidValue.
 //
-// Marshal uses fmt.Sprintf to transform encountered values to its default
-// string format. Values without the "env" field tag are ignored.
+// Values with the "json" tag option are formatted with json.Marshal, and []byte
+// values are encoded as base64, or with the encoding given by the "encoding"
+// tag option.
+//
+// Otherwise, values implementing Marshaler are formatted with MarshalEnv. A
+// time.Time is formatted with the layout given by the "layout" tag option, or
+// the first of the "layouts" tag option, or time.RFC3339 by default, and a
+// url.URL is formatted with URL.String. A time.Duration is written in its
+// Duration.String form, or as a whole count of the unit given by the
+// "durationAs" tag option, returning ErrInexactDuration if it isn't one. Values
+// implementing encoding.TextMarshaler are formatted with MarshalText, and
+// values implementing flag.Value are formatted with String.
+//
+// Remaining values are formatted according to their kind. Booleans are written
+// with the words given by the "true" and "false" tag options, if any. Integers
+// are written in the base given by the "base" tag option, or base 10 if it's
+// absent or 0, and signed ints with the "requireSign" tag option are written
+// with a leading "+" unless negative. Strings, booleans, integers and floats of
+// a named type are written by their underlying kind, ignoring any String
+// method, so that Unmarshal can parse them back; implement Marshaler to write a
+// named type differently. Only values of any other kind fall back to
+// fmt.Sprintf and their default string format.
+//
+// Slices and arrays are joined with commas, or with the value of the
+// "separator" tag option. A slice of an unsupported element type returns
+// ErrUnsupportedType. Maps are joined the same way as "key=value" pairs, sorted
+// by key, with integer keys in numeric order. A slice with the "indexed" tag
+// option is written as one key per element, e.g. ARG_1 and ARG_2 for
+// `env:"ARG,indexed"`. Values are written under their primary key only, never
+// their aliases.
 //
-// Nested structs are traversed recursively.
+// Values without the "env" field tag, or tagged `env:"-"`, are ignored, as are
+// empty values with the "omitempty" tag option, e.g. `env:"COUNT,omitempty"`,
+// where empty has the same meaning as for encoding/json.
+//
+// Nested structs, and non-nil pointers to structs, are traversed recursively,
+// unless they have a key and are formatted as a single value, as for
+// Unmarshal; any other struct field with a key returns ErrInvalidTag. A
+// nested struct field with the "prefix" tag option, e.g. `env:"DB_,prefix"`,
+// prepends its key to the keys of its fields. Embedded structs are traversed
+// the same way, even if their type is unexported.
 func Marshal(v interface{}) (EnvSet, error) {
+	return MarshalWithOptions(v, Options{})
+}
+
+// MarshalWithOptions behaves like Marshal, with its behavior adjusted by opts.
+// Marshal is equivalent to MarshalWithOptions with zero-value Options.
+func MarshalWithOptions(v interface{}, opts Options) (EnvSet, error) {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
 		return nil, ErrInvalidValue
@@ -197,71 +1178,497 @@ func Marshal(v interface{}) (EnvSet, error) {
 		return nil, ErrInvalidValue
 	}
 
-	es := make(EnvSet)
+	e := encoder{es: make(EnvSet), opts: opts}
+	err := e.encode(rv, "")
+	if err != nil {
+		return nil, err
+	}
+	return e.es, nil
+}
+
+// MarshalToEnviron marshals v like Marshal and sets each resulting key in the
+// environment of the running process with os.Setenv, in key order. It mutates
+// global process state, which is visible to the whole program and inherited by
+// child processes. If setting a key fails, MarshalToEnviron stops and returns a
+// *FieldError naming that key; keys set before it are not rolled back.
+func MarshalToEnviron(v interface{}) error {
+	es, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(es))
+	for k := range es {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		err := os.Setenv(k, es[k])
+		if err != nil {
+			return &FieldError{Key: k, Err: err}
+		}
+	}
+	return nil
+}
+
+// encoder holds the state of a single call to marshal into an EnvSet.
+type encoder struct {
+	es   EnvSet
+	opts Options
+}
+
+// encode stores the fields of the struct rv in the EnvSet, prepending prefix
+// to every key.
+func (e *encoder) encode(rv reflect.Value, prefix string) error {
 	t := rv.Type()
+	tags := tagsOf(t, e.opts)
 	for i := 0; i < rv.NumField(); i++ {
 		valueField := rv.Field(i)
-		switch valueField.Kind() {
-		case reflect.Slice:
-			typeField := t.Field(i)
-			tag := typeField.Tag.Get("env")
-			if tag == "" {
-				continue
+		typeField := t.Field(i)
+		tag := tags[i]
+		if tag.skip() {
+			continue
+		}
+
+		if _, ok := tag.option("prefix"); ok {
+			tag.key = prefix + tag.key
+			if !isStruct(typeField.Type) {
+				return tag.invalid()
 			}
-			switch valueField.Type().Elem().Kind() {
-			case reflect.String:
-				slice, ok := valueField.Interface().([]string)
-				if !ok {
-					return nil, ErrUnsupportedType
-				}
-				es[tag] = strings.Join(slice, ",")
-				continue
-			case reflect.Int:
-				slice, ok := valueField.Interface().([]int)
-				if !ok {
-					return nil, ErrUnsupportedType
-				}
-				b := make([]string, len(slice))
-				for i, v := range slice {
-					b[i] = strconv.Itoa(v)
-				}
-				es[tag] = strings.Join(b, ",")
-				continue
-			default:
+
+			if !isTraversable(typeField) {
 				continue
 			}
-			break
-		case reflect.Struct:
-			if !valueField.Addr().CanInterface() {
-				continue
+			err := e.encodeStruct(valueField, tag.key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if isStruct(typeField.Type) && isTraversable(typeField) && !isValue(typeField.Type, tag) {
+			if tag.key != "" {
+				tag.key = prefix + tag.key
+				return tag.invalid()
 			}
 
-			iface := valueField.Addr().Interface()
-			nes, err := Marshal(iface)
+			err := e.encodeStruct(valueField, prefix)
 			if err != nil {
-				return nil, err
+				return err
 			}
+			continue
+		}
+
+		if tag.key == "" {
+			continue
+		}
+		tag.key = prefix + tag.key
 
-			for k, v := range nes {
-				es[k] = v
+		if _, ok := tag.option("indexed"); ok {
+			err := e.encodeIndexed(typeField.Type, valueField, tag)
+			if err != nil {
+				return err
 			}
+			continue
 		}
 
-		typeField := t.Field(i)
-		tag := typeField.Tag.Get("env")
-		if tag == "" {
+		if _, ok := tag.option("omitempty"); (ok || e.opts.OmitEmpty) && isEmptyValue(valueField) {
 			continue
 		}
 
-		if typeField.Type.Kind() == reflect.Ptr {
-			if valueField.IsNil() {
+		if e.opts.OmitDefaults {
+			isDefault, err := e.isDefault(typeField.Type, valueField, tag)
+			if err != nil {
+				return err
+			}
+			if isDefault {
 				continue
 			}
-			es[tag] = fmt.Sprintf("%v", valueField.Elem().Interface())
-		} else {
-			es[tag] = fmt.Sprintf("%v", valueField.Interface())
 		}
+
+		value, ok, err := get(typeField.Type, valueField, tag)
+		if err != nil {
+			return err
+		}
+		if ok {
+			e.es[e.key(tag.key)] = value
+		}
+	}
+
+	return nil
+}
+
+// encodeStruct stores the fields of the struct, or pointer to struct, f in the
+// EnvSet. A nil pointer is skipped.
+func (e *encoder) encodeStruct(f reflect.Value, prefix string) error {
+	if f.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			return nil
+		}
+		return e.encodeStruct(f.Elem(), prefix)
+	}
+	return e.encode(f, prefix)
+}
+
+// key returns the key to store a value under, transformed by
+// Options.MarshalKey if set.
+func (e *encoder) key(k string) string {
+	if e.opts.MarshalKey != nil {
+		return e.opts.MarshalKey(k)
+	}
+	return k
+}
+
+// encodeIndexed stores the elements of the slice f under the keys KEY_1,
+// KEY_2 and so on.
+func (e *encoder) encodeIndexed(t reflect.Type, f reflect.Value, tag fieldTag) error {
+	if t.Kind() != reflect.Slice {
+		return tag.invalid()
+	}
+
+	for i := 0; i < f.Len(); i++ {
+		value, ok, err := get(t.Elem(), f.Index(i), tag)
+		if err != nil {
+			return err
+		}
+		if ok {
+			e.es[e.key(tag.key+"_"+strconv.Itoa(i+1))] = value
+		}
+	}
+	return nil
+}
+
+// isDefault reports whether f holds the value of the "default" tag option.
+func (e *encoder) isDefault(t reflect.Type, f reflect.Value, tag fieldTag) (bool, error) {
+	v, ok := tag.option("default")
+	if !ok {
+		return false, nil
+	}
+
+	def := reflect.New(t).Elem()
+	err := set(t, def, v, tag, e.opts)
+	if err != nil {
+		return false, &FieldError{Key: tag.key, Err: err}
+	}
+	return reflect.DeepEqual(f.Interface(), def.Interface()), nil
+}
+
+// isTraversable reports whether the nested struct field f is traversed: it
+// must be exported, or embedded so that its exported fields are promoted even
+// if its type is unexported.
+func isTraversable(f reflect.StructField) bool {
+	return f.IsExported() || f.Anonymous
+}
+
+// isEmptyValue reports whether v is empty as defined by the "omitempty" option
+// of encoding/json: false, 0, a nil pointer or interface, or an empty array,
+// slice, map or string.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isMapKey reports whether t is a supported map key type: a string, bool or
+// integer, parsed and formatted like a slice element.
+func isMapKey(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+// lessMapKey orders map keys by value, numerically for integers, so that
+// marshalled maps are deterministic.
+func lessMapKey(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Uint() < b.Uint()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	default:
+		return a.String() < b.String()
+	}
+}
+
+// isStruct reports whether t is a struct or a pointer to a struct.
+func isStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// valueInterfaces holds the interfaces through which a struct parses or
+// formats itself as a single value.
+var valueInterfaces = []reflect.Type{
+	reflect.TypeOf((*Unmarshaler)(nil)).Elem(),
+	reflect.TypeOf((*Marshaler)(nil)).Elem(),
+	reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem(),
+	reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem(),
+	reflect.TypeOf((*flag.Value)(nil)).Elem(),
+}
+
+// isValue reports whether a struct field of type t with the tag tag is
+// unmarshalled as a single value under its key rather than traversed: it
+// must have a key, and be a value type as isValueType reports.
+func isValue(t reflect.Type, tag fieldTag) bool {
+	return tag.key != "" && isValueType(t, tag)
+}
+
+// isValueType reports whether a struct field of type t with the tag tag can be
+// parsed as a single value: it has either the "json" tag option or a type, or
+// pointer to it, that is url.URL or implements one of valueInterfaces.
+func isValueType(t reflect.Type, tag fieldTag) bool {
+	if _, ok := tag.option("json"); ok {
+		return true
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == urlType {
+		return true
+	}
+	ptr := reflect.PtrTo(t)
+	for _, i := range valueInterfaces {
+		if ptr.Implements(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the string form of f, the inverse of set. If there is nothing to
+// write for f, such as for a nil pointer, get returns false.
+func get(t reflect.Type, f reflect.Value, tag fieldTag) (string, bool, error) {
+	if t.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			return "", false, nil
+		}
+		return get(t.Elem(), f.Elem(), tag)
+	}
+
+	if _, ok := tag.option("json"); ok {
+		b, err := json.Marshal(f.Interface())
+		if err != nil {
+			return "", false, err
+		}
+		return string(b), true, nil
+	}
+
+	if _, ok := tag.option("encoding"); ok || t == bytesType {
+		enc, known := tag.encoding()
+		if !known || t != bytesType {
+			return "", false, tag.invalid()
+		}
+		return enc.EncodeToString(f.Bytes()), true, nil
+	}
+
+	if m, ok := envMarshaler(f); ok {
+		value, err := m.MarshalEnv()
+		if err != nil {
+			return "", false, err
+		}
+		return value, true, nil
+	}
+
+	if t == timeType {
+		return f.Interface().(time.Time).Format(tag.layout()), true, nil
+	}
+
+	if t == urlType {
+		u := f.Interface().(url.URL)
+		return u.String(), true, nil
+	}
+
+	if t == durationType {
+		if unit, ok := tag.durationUnit(); ok {
+			if f.Int()%int64(unit) != 0 {
+				return "", false, &FieldError{Key: tag.key, Err: ErrInexactDuration}
+			}
+			return strconv.FormatInt(f.Int()/int64(unit), 10), true, nil
+		}
+		return f.Interface().(time.Duration).String(), true, nil
+	}
+
+	if m, ok := textMarshaler(f); ok {
+		b, err := m.MarshalText()
+		if err != nil {
+			return "", false, err
+		}
+		return string(b), true, nil
+	}
+
+	if v, ok := flagValue(f); ok {
+		return v.String(), true, nil
+	}
+
+	// named types are formatted by their kind rather than a String method, so
+	// that the result is what set parses
+	switch t.Kind() {
+	case reflect.String:
+		return f.String(), true, nil
+	case reflect.Bool:
+		return tag.boolWord(f.Bool()), true, nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(f.Float(), 'g', -1, t.Bits()), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value := strconv.FormatInt(f.Int(), tag.formatBase())
+		if _, ok := tag.option("requireSign"); ok && f.Int() >= 0 {
+			value = "+" + value
+		}
+		return value, true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(f.Uint(), tag.formatBase()), true, nil
+	case reflect.Slice, reflect.Array:
+		b := make([]string, f.Len())
+		for i := range b {
+			element, err := getElement(t.Elem(), f.Index(i), tag)
+			if err != nil {
+				return "", false, err
+			}
+			b[i] = element
+		}
+		return strings.Join(b, tag.separator()), true, nil
+	case reflect.Map:
+		if !isMapKey(t.Key()) {
+			return "", false, ErrUnsupportedType
+		}
+
+		keys := f.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return lessMapKey(keys[i], keys[j])
+		})
+
+		b := make([]string, len(keys))
+		for i, k := range keys {
+			key, err := getElement(t.Key(), k, tag)
+			if err != nil {
+				return "", false, err
+			}
+			value, _, err := get(t.Elem(), f.MapIndex(k), tag)
+			if err != nil {
+				return "", false, err
+			}
+			b[i] = key + "=" + value
+		}
+		return strings.Join(b, tag.separator()), true, nil
+	default:
+		return fmt.Sprintf("%v", f.Interface()), true, nil
+	}
+}
+
+// getElement formats a single slice or array element of type t. A nil pointer
+// element is formatted as the empty string.
+func getElement(t reflect.Type, f reflect.Value, tag fieldTag) (string, error) {
+	if t == durationType {
+		return time.Duration(f.Int()).String(), nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return f.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(f.Int(), tag.formatBase()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(f.Uint(), tag.formatBase()), nil
+	case reflect.Bool:
+		return tag.boolWord(f.Bool()), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(f.Float(), 'g', -1, t.Bits()), nil
+	case reflect.Ptr:
+		if f.IsNil() {
+			return "", nil
+		}
+		return getElement(t.Elem(), f.Elem(), tag)
+	default:
+		return "", ErrUnsupportedType
+	}
+}
+
+// envMarshaler returns f as a Marshaler, checking both f and a pointer to f so
+// that methods with pointer receivers are found.
+func envMarshaler(f reflect.Value) (Marshaler, bool) {
+	if m, ok := f.Interface().(Marshaler); ok {
+		return m, true
+	}
+	if f.CanAddr() {
+		m, ok := f.Addr().Interface().(Marshaler)
+		return m, ok
+	}
+	return nil, false
+}
+
+// textMarshaler returns f as an encoding.TextMarshaler, checking both f and a
+// pointer to f so that methods with pointer receivers are found.
+func textMarshaler(f reflect.Value) (encoding.TextMarshaler, bool) {
+	if m, ok := f.Interface().(encoding.TextMarshaler); ok {
+		return m, true
+	}
+	if f.CanAddr() {
+		m, ok := f.Addr().Interface().(encoding.TextMarshaler)
+		return m, ok
+	}
+	return nil, false
+}
+
+// flagValue returns f as a flag.Value, checking both f and a pointer to f so
+// that methods with pointer receivers are found.
+func flagValue(f reflect.Value) (flag.Value, bool) {
+	if v, ok := f.Interface().(flag.Value); ok {
+		return v, true
+	}
+	if f.CanAddr() {
+		v, ok := f.Addr().Interface().(flag.Value)
+		return v, ok
+	}
+	return nil, false
+}
+
+// parseBool parses value with strconv.ParseBool, unless tag declares its own
+// words for true and false. If Options.LooseBools is set, the words yes/no,
+// y/n, on/off and enabled/disabled are accepted as well, in any case.
+func parseBool(value string, tag fieldTag, opts Options) (bool, error) {
+	if !tag.hasBoolWords() {
+		b, err := strconv.ParseBool(value)
+		if err == nil || !opts.LooseBools {
+			return b, err
+		}
+
+		switch strings.ToLower(value) {
+		case "yes", "y", "on", "enabled":
+			return true, nil
+		case "no", "n", "off", "disabled":
+			return false, nil
+		}
+		return false, err
 	}
 
-	return es, nil
+	switch value {
+	case tag.boolWord(true):
+		return true, nil
+	case tag.boolWord(false):
+		return false, nil
+	}
+	return false, &strconv.NumError{Func: "ParseBool", Num: value, Err: strconv.ErrSyntax}
 }
@@ -0,0 +1,129 @@
+// Copyright 2018 Netflix, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package envtest provides helpers for testing types that are marshalled and
+// unmarshalled with package env.
+package envtest
+
+import (
+	"reflect"
+
+	env "github.com/Netflix/go-env"
+)
+
+// TB is the subset of testing.TB used by the helpers in this package.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertRoundTrip marshals v, unmarshals the result into a fresh value of the
+// same type and reports an error on t if the two values differ. v must be a
+// non-nil pointer to a struct, as accepted by env.Marshal.
+//
+// A nil slice or map is considered equal to an empty one, since env writes
+// both as an empty value. A value fails to round trip when it has populated
+// fields that env cannot represent, such as fields without an "env" field tag.
+func AssertRoundTrip(t TB, v interface{}) {
+	t.Helper()
+
+	es, err := env.Marshal(v)
+	if err != nil {
+		t.Errorf("Expected no error marshalling but got '%s'", err)
+		return
+	}
+
+	want := reflect.ValueOf(v).Elem()
+	got := reflect.New(want.Type())
+	err = env.Unmarshal(es, got.Interface())
+	if err != nil {
+		t.Errorf("Expected no error unmarshalling but got '%s'", err)
+		return
+	}
+
+	if !equal(got.Elem(), want) {
+		t.Errorf("Expected round trip value to be '%+v' but got '%+v'", want.Interface(), got.Elem().Interface())
+	}
+}
+
+// equal reports whether a and b, of the same type, are deeply equal as
+// reflect.DeepEqual reports, except that a nil slice or map is equal to an
+// empty one.
+func equal(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equal(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.Len() != b.Len() {
+			return false
+		}
+		iter := a.MapRange()
+		for iter.Next() {
+			v := b.MapIndex(iter.Key())
+			if !v.IsValid() || !equal(iter.Value(), v) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if !equal(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !equal(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equal(a.Elem(), b.Elem())
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return a.Elem().Type() == b.Elem().Type() && equal(a.Elem(), b.Elem())
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() == b.Float()
+	case reflect.Complex64, reflect.Complex128:
+		return a.Complex() == b.Complex()
+	case reflect.String:
+		return a.String() == b.String()
+	case reflect.Func:
+		// as for reflect.DeepEqual, funcs are only equal if both are nil
+		return a.IsNil() && b.IsNil()
+	default:
+		return a.Pointer() == b.Pointer()
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Netflix, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package envtest
+
+import (
+	"fmt"
+	"testing"
+)
+
+type recorder struct {
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+type RoundTrippable struct {
+	Home  string   `env:"HOME"`
+	Port  int      `env:"PORT"`
+	Hosts []string `env:"HOSTS"`
+}
+
+type NotRoundTrippable struct {
+	Home string `env:"HOME"`
+
+	// Extra is lost on the way through an EnvSet because it has no tag.
+	Extra string
+}
+
+func TestAssertRoundTrip(t *testing.T) {
+	var r recorder
+	AssertRoundTrip(&r, &RoundTrippable{
+		Home:  "/home/test",
+		Port:  8080,
+		Hosts: []string{"a", "b"},
+	})
+
+	if len(r.errors) != 0 {
+		t.Errorf("Expected no errors but got '%v'", r.errors)
+	}
+}
+
+type ZeroRoundTrippable struct {
+	Hosts []string          `env:"HOSTS"`
+	Ports []int             `env:"PORTS"`
+	M     map[string]string `env:"M"`
+}
+
+func TestAssertRoundTripZero(t *testing.T) {
+	var r recorder
+	AssertRoundTrip(&r, &ZeroRoundTrippable{})
+
+	if len(r.errors) != 0 {
+		t.Errorf("Expected no errors but got '%v'", r.errors)
+	}
+
+	AssertRoundTrip(&r, &ZeroRoundTrippable{
+		Hosts: []string{},
+		Ports: []int{},
+		M:     map[string]string{},
+	})
+
+	if len(r.errors) != 0 {
+		t.Errorf("Expected no errors but got '%v'", r.errors)
+	}
+}
+
+func TestAssertRoundTripMismatch(t *testing.T) {
+	var r recorder
+	AssertRoundTrip(&r, &NotRoundTrippable{
+		Home:  "/home/test",
+		Extra: "extra",
+	})
+
+	if len(r.errors) != 1 {
+		t.Errorf("Expected %d error but got '%v'", 1, r.errors)
+	}
+}
+
+func TestAssertRoundTripInvalid(t *testing.T) {
+	var r recorder
+	AssertRoundTrip(&r, RoundTrippable{})
+
+	if len(r.errors) != 1 {
+		t.Errorf("Expected %d error but got '%v'", 1, r.errors)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 Netflix, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package env
+
+// Options configures the behavior of UnmarshalWithOptions and
+// MarshalWithOptions. The zero value matches the behavior of Unmarshal and
+// Marshal.
+type Options struct {
+	// AppendSlices appends parsed elements to a slice field that is already
+	// populated, e.g. with code defaults, instead of replacing it.
+	AppendSlices bool
+
+	// CollapseEmpty drops empty elements when splitting slice values, so
+	// "a,,b," is parsed as [a b] instead of keeping the empty strings.
+	CollapseEmpty bool
+
+	// OmitDefaults skips fields when marshalling if they hold the value of
+	// their "default" tag option, producing only the overrides.
+	OmitDefaults bool
+
+	// OmitEmpty skips fields when marshalling if they hold an empty value,
+	// as if every field had the "omitempty" tag option: false, 0, a nil
+	// pointer, or an empty string, slice or map, as for encoding/json.
+	OmitEmpty bool
+
+	// ValidateKeys makes unmarshalling return ErrEmptyKey if the EnvSet
+	// contains the empty key, which only arises from malformed input such as
+	// an "=value" item passed to EnvironToEnvSet.
+	ValidateKeys bool
+
+	// Expand replaces ${VAR} and $VAR references in values, including tag
+	// defaults, with the value of VAR from the EnvSet before parsing, e.g.
+	// BASE_URL=https://${HOST}:${PORT}. Referenced values are expanded in
+	// turn, and a cycle of references returns ErrCyclicReference.
+	// References to missing keys expand to the empty string.
+	Expand bool
+
+	// ExpandStrict makes a reference to a missing key return
+	// ErrUnresolvedReference instead of expanding to the empty string. It
+	// only applies if Expand is set.
+	ExpandStrict bool
+
+	// CaseInsensitive matches field keys against EnvSet keys regardless of
+	// case, so a field tagged `env:"PORT"` is set from a "port" key. A key
+	// that matches exactly takes precedence; otherwise several keys that
+	// differ only by case, e.g. "port" and "Port", return ErrAmbiguousKey.
+	CaseInsensitive bool
+
+	// Separator replaces the comma as the default separator of slice, array
+	// and map values. A field's "separator" tag option still takes
+	// precedence. Unlike the tag option, it may contain a comma, e.g. ", ".
+	Separator string
+
+	// CollectErrors makes unmarshalling attempt every field rather than stop
+	// at the first that fails, returning the failures joined with
+	// errors.Join, as UnmarshalAll does.
+	CollectErrors bool
+
+	// TagName replaces "env" as the name of the struct field tag that is
+	// read, e.g. "cfg" for fields tagged `cfg:"PORT"`.
+	TagName string
+
+	// Logger, if set, receives diagnostics while unmarshalling, such as
+	// fields that are skipped and defaults that are applied. Nothing is
+	// logged by default.
+	Logger func(format string, args ...interface{})
+
+	// MaxValueLen, if positive, makes unmarshalling return ErrValueTooLong
+	// for a field whose value, after any expansion, is longer than
+	// MaxValueLen bytes, before the value is parsed.
+	MaxValueLen int
+
+	// LooseBools makes bool fields, elements and map values accept the words
+	// yes/no, y/n, on/off and enabled/disabled in any case, in addition to the
+	// forms accepted by strconv.ParseBool. It doesn't apply to fields with
+	// the "true" and "false" tag options. Marshal still writes true and false.
+	LooseBools bool
+
+	// StrictPrefix, if set, makes unmarshalling return ErrUnknownKey for
+	// every key with this prefix that no field matched, e.g. a misspelled
+	// "APP_DATABSE_URL" with the prefix "APP_". Each unknown key is reported
+	// in a *FieldError, joined with errors.Join. Keys without the prefix are
+	// ignored, since the process environment holds many unrelated variables.
+	StrictPrefix string
+
+	// AutoName gives exported fields without a key in their tag a key derived
+	// from the field name in screaming snake case, e.g. MAX_CONNECTIONS for
+	// MaxConnections and HTTP_PORT for HTTPPort, both when unmarshalling and
+	// marshalling. An explicit key still wins, `env:"-"` still skips the
+	// field, and nested structs are still traversed rather than named, unless
+	// they're parsed as a single value, such as time.Time or big.Int.
+	AutoName bool
+
+	// AutoSlice parses a slice value that starts with "[" as a JSON array,
+	// e.g. ["a","b"], and splits any other value on the separator as usual,
+	// so that a field accepts either format.
+	AutoSlice bool
+
+	// MarshalKey, if set, transforms every key written by marshalling, e.g.
+	// strings.ToLower for systems that expect lowercase keys. It is applied
+	// to the complete key, after any prefixes.
+	MarshalKey func(key string) string
+
+	// TrimSpace removes leading and trailing whitespace from values before
+	// parsing them, e.g. " 8080 ", and from each slice element and map key and
+	// value after splitting. Strings, which may intentionally hold surrounding
+	// whitespace, are only trimmed if the field also has the "trim" tag
+	// option; this includes the elements of a []string and the string keys
+	// and values of a map, which earlier versions trimmed.
+	TrimSpace bool
+
+	// URLDecodeValues decodes each value found in the EnvSet with
+	// url.QueryUnescape before parsing it, for values passed through query
+	// strings, e.g. "a%2Cb" or "hello+world". Defaults are not decoded.
+	URLDecodeValues bool
+
+	// ValidateDefaults parses the values of the "default" and "defaultIf" tag
+	// options of every field, returning an error naming the key for any that
+	// can't be parsed, even if the key is present and the default unused.
+	ValidateDefaults bool
+}
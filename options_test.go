@@ -0,0 +1,1330 @@
+// Copyright 2018 Netflix, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package env
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalWithOptionsAppendSlices(t *testing.T) {
+	environ := map[string]string{
+		"SLICE_STRING": "string2,string3",
+	}
+
+	validStruct := ValidStruct{
+		SliceString: []string{"string1"},
+	}
+	err := UnmarshalWithOptions(environ, &validStruct, Options{AppendSlices: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	stringSlice := []string{"string1", "string2", "string3"}
+	if !reflect.DeepEqual(validStruct.SliceString, stringSlice) {
+		t.Errorf("Expected field value to be '%s' but got '%s'", stringSlice, validStruct.SliceString)
+	}
+}
+
+func TestUnmarshalWithOptionsReplaceSlices(t *testing.T) {
+	environ := map[string]string{
+		"SLICE_STRING": "string2,string3",
+	}
+
+	validStruct := ValidStruct{
+		SliceString: []string{"string1"},
+	}
+	err := UnmarshalWithOptions(environ, &validStruct, Options{})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	stringSlice := []string{"string2", "string3"}
+	if !reflect.DeepEqual(validStruct.SliceString, stringSlice) {
+		t.Errorf("Expected field value to be '%s' but got '%s'", stringSlice, validStruct.SliceString)
+	}
+}
+
+func TestUnmarshalWithOptionsCollapseEmpty(t *testing.T) {
+	tests := map[string][]string{
+		",a,b":     {"a", "b"},
+		"a,b,,":    {"a", "b"},
+		"a,,b,,,c": {"a", "b", "c"},
+		",,":       {},
+	}
+
+	for value, expected := range tests {
+		environ := map[string]string{
+			"SLICE_STRING": value,
+		}
+
+		var validStruct ValidStruct
+		err := UnmarshalWithOptions(environ, &validStruct, Options{CollapseEmpty: true})
+		if err != nil {
+			t.Errorf("Expected no error but got '%s'", err)
+		}
+
+		if !reflect.DeepEqual(validStruct.SliceString, expected) {
+			t.Errorf("Expected field value to be '%q' but got '%q'", expected, validStruct.SliceString)
+		}
+	}
+}
+
+func TestUnmarshalWithOptionsKeepEmpty(t *testing.T) {
+	environ := map[string]string{
+		"SLICE_STRING": "a,,b",
+	}
+
+	var validStruct ValidStruct
+	err := UnmarshalWithOptions(environ, &validStruct, Options{})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	stringSlice := []string{"a", "", "b"}
+	if !reflect.DeepEqual(validStruct.SliceString, stringSlice) {
+		t.Errorf("Expected field value to be '%q' but got '%q'", stringSlice, validStruct.SliceString)
+	}
+}
+
+func TestMarshalWithOptionsOmitDefaults(t *testing.T) {
+	timeout := 30 * time.Second
+	defaultStruct := DefaultStruct{
+		Port:    8081,
+		Host:    "localhost",
+		Timeout: &timeout,
+	}
+
+	es, err := MarshalWithOptions(&defaultStruct, Options{OmitDefaults: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := EnvSet{
+		"PORT": "8081",
+	}
+	if !reflect.DeepEqual(es, expected) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", expected, es)
+	}
+
+	es, err = MarshalWithOptions(&defaultStruct, Options{})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if es["HOST"] != "localhost" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "localhost", es["HOST"])
+	}
+}
+
+func TestUnmarshalWithOptionsValidateKeys(t *testing.T) {
+	environ := map[string]string{
+		"HOME": "/home/test",
+		"":     "value",
+	}
+
+	var validStruct ValidStruct
+	err := UnmarshalWithOptions(environ, &validStruct, Options{ValidateKeys: true})
+	if err != ErrEmptyKey {
+		t.Errorf("Expected error 'ErrEmptyKey' but got '%v'", err)
+	}
+
+	err = UnmarshalWithOptions(environ, &validStruct, Options{})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+}
+
+type ExpandStruct struct {
+	Host    string `env:"HOST"`
+	BaseURL string `env:"BASE_URL"`
+}
+
+func TestUnmarshalWithOptionsExpand(t *testing.T) {
+	environ := map[string]string{
+		"HOST":     "example.com",
+		"PORT":     "8443",
+		"BASE_URL": "https://${HOST}:$PORT",
+	}
+
+	var expandStruct ExpandStruct
+	err := UnmarshalWithOptions(environ, &expandStruct, Options{Expand: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if expandStruct.BaseURL != "https://example.com:8443" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "https://example.com:8443", expandStruct.BaseURL)
+	}
+
+	environ = map[string]string{
+		"BASE_URL": "https://${HOST}",
+	}
+	expandStruct = ExpandStruct{}
+	err = UnmarshalWithOptions(environ, &expandStruct, Options{})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if expandStruct.BaseURL != "https://${HOST}" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "https://${HOST}", expandStruct.BaseURL)
+	}
+}
+
+func TestUnmarshalWithOptionsExpandUnresolved(t *testing.T) {
+	environ := map[string]string{
+		"BASE_URL": "https://${HOST}/",
+	}
+
+	var expandStruct ExpandStruct
+	err := UnmarshalWithOptions(environ, &expandStruct, Options{Expand: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if expandStruct.BaseURL != "https:///" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "https:///", expandStruct.BaseURL)
+	}
+
+	environ = map[string]string{
+		"BASE_URL": "https://${HOST}/",
+	}
+	err = UnmarshalWithOptions(environ, &expandStruct, Options{Expand: true, ExpandStrict: true})
+	if !errors.Is(err, ErrUnresolvedReference) {
+		t.Errorf("Expected error 'ErrUnresolvedReference' but got '%v'", err)
+	}
+}
+
+func TestUnmarshalWithOptionsExpandCycle(t *testing.T) {
+	environ := map[string]string{
+		"HOST":     "${BASE_URL}",
+		"BASE_URL": "https://${HOST}",
+	}
+
+	var expandStruct ExpandStruct
+	err := UnmarshalWithOptions(environ, &expandStruct, Options{Expand: true})
+	if !errors.Is(err, ErrCyclicReference) {
+		t.Errorf("Expected error 'ErrCyclicReference' but got '%v'", err)
+	}
+
+	environ = map[string]string{
+		"HOST": "${HOST}",
+	}
+	err = UnmarshalWithOptions(environ, &expandStruct, Options{Expand: true})
+	if !errors.Is(err, ErrCyclicReference) {
+		t.Errorf("Expected error 'ErrCyclicReference' but got '%v'", err)
+	}
+}
+
+func TestUnmarshalWithOptionsCaseInsensitive(t *testing.T) {
+	environ := map[string]string{
+		"port": "9000",
+	}
+
+	var defaultStruct DefaultStruct
+	err := UnmarshalWithOptions(environ, &defaultStruct, Options{CaseInsensitive: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if defaultStruct.Port != 9000 {
+		t.Errorf("Expected field value to be '%d' but got '%d'", 9000, defaultStruct.Port)
+	}
+
+	if _, ok := environ["port"]; ok {
+		t.Errorf("Expected key '%s' to be consumed", "port")
+	}
+
+	environ = map[string]string{
+		"port": "8080",
+		"PORT": "9090",
+		"Port": "7070",
+	}
+	err = UnmarshalWithOptions(environ, &defaultStruct, Options{CaseInsensitive: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if defaultStruct.Port != 9090 {
+		t.Errorf("Expected field value to be '%d' but got '%d'", 9090, defaultStruct.Port)
+	}
+
+	environ = map[string]string{
+		"port": "8080",
+		"Port": "7070",
+	}
+	err = UnmarshalWithOptions(environ, &defaultStruct, Options{CaseInsensitive: true})
+	if !errors.Is(err, ErrAmbiguousKey) {
+		t.Errorf("Expected error 'ErrAmbiguousKey' but got '%v'", err)
+	}
+
+	// a default must not consume an unrelated key, such as the empty one
+	environ = map[string]string{
+		"":      "empty",
+		"OTHER": "other",
+	}
+	defaultStruct = DefaultStruct{}
+	err = UnmarshalWithOptions(environ, &defaultStruct, Options{CaseInsensitive: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if defaultStruct.Port != 8080 {
+		t.Errorf("Expected field value to be '%d' but got '%d'", 8080, defaultStruct.Port)
+	}
+
+	expected := map[string]string{
+		"":      "empty",
+		"OTHER": "other",
+	}
+	if !reflect.DeepEqual(environ, expected) {
+		t.Errorf("Expected remaining environ to be '%v' but got '%v'", expected, environ)
+	}
+}
+
+type CfgStruct struct {
+	Host  string   `cfg:"HOST"`
+	Port  int      `cfg:"PORT,default=8080"`
+	Hosts []string `cfg:"HOSTS"`
+	Other string   `env:"OTHER"`
+}
+
+func TestWithOptionsTagName(t *testing.T) {
+	environ := map[string]string{
+		"HOST":  "example.com",
+		"HOSTS": "a,b",
+		"OTHER": "other",
+	}
+
+	var cfgStruct CfgStruct
+	err := UnmarshalWithOptions(environ, &cfgStruct, Options{TagName: "cfg"})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := CfgStruct{
+		Host:  "example.com",
+		Port:  8080,
+		Hosts: []string{"a", "b"},
+	}
+	if !reflect.DeepEqual(cfgStruct, expected) {
+		t.Errorf("Expected field value to be '%v' but got '%v'", expected, cfgStruct)
+	}
+
+	if environ["OTHER"] != "other" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "other", environ["OTHER"])
+	}
+
+	es, err := MarshalWithOptions(&cfgStruct, Options{TagName: "cfg"})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expectedEs := EnvSet{
+		"HOST":  "example.com",
+		"PORT":  "8080",
+		"HOSTS": "a,b",
+	}
+	if !reflect.DeepEqual(es, expectedEs) {
+		t.Errorf("Expected field value to be '%v' but got '%v'", expectedEs, es)
+	}
+}
+
+func TestWithOptionsSeparator(t *testing.T) {
+	environ := map[string]string{
+		"SLICE_STRING": "string1;string2",
+	}
+
+	var validStruct ValidStruct
+	err := UnmarshalWithOptions(environ, &validStruct, Options{Separator: ";"})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	stringSlice := []string{"string1", "string2"}
+	if !reflect.DeepEqual(validStruct.SliceString, stringSlice) {
+		t.Errorf("Expected field value to be '%s' but got '%s'", stringSlice, validStruct.SliceString)
+	}
+
+	es, err := MarshalWithOptions(&validStruct, Options{Separator: ";"})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if es["SLICE_STRING"] != "string1;string2" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "string1;string2", es["SLICE_STRING"])
+	}
+}
+
+func TestUnmarshalWithOptionsCollectErrors(t *testing.T) {
+	environ := map[string]string{
+		"PORT": "not-a-port",
+	}
+
+	type CollectStruct struct {
+		DatabaseURL string `env:"DATABASE_URL,required"`
+		Port        int    `env:"PORT"`
+	}
+
+	var collectStruct CollectStruct
+	err := UnmarshalWithOptions(environ, &collectStruct, Options{CollectErrors: true})
+	if !errors.Is(err, ErrMissingRequiredValue) {
+		t.Errorf("Expected error 'ErrMissingRequiredValue' but got '%v'", err)
+	}
+
+	var numErr *strconv.NumError
+	if !errors.As(err, &numErr) {
+		t.Errorf("Expected error '*strconv.NumError' but got '%v'", err)
+	}
+}
+
+func TestUnmarshalWithOptionsLogger(t *testing.T) {
+	environ := map[string]string{
+		"HOME": "/home/test",
+	}
+
+	var logged []string
+	logger := func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+
+	var skipStruct SkipStruct
+	err := UnmarshalWithOptions(environ, &skipStruct, Options{Logger: logger})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := []string{`skipping field Secret tagged "-"`}
+	if !reflect.DeepEqual(logged, expected) {
+		t.Errorf("Expected logged messages to be '%v' but got '%v'", expected, logged)
+	}
+
+	logged = nil
+	type LoggedDefaultStruct struct {
+		Host string `env:"HOST,default=localhost"`
+		Port int    `env:"PORT"`
+	}
+
+	var loggedDefaultStruct LoggedDefaultStruct
+	err = UnmarshalWithOptions(EnvSet{}, &loggedDefaultStruct, Options{Logger: logger})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected = []string{
+		`HOST: missing, using default "localhost" for field Host`,
+		`PORT: missing, leaving field Port unset`,
+	}
+	if !reflect.DeepEqual(logged, expected) {
+		t.Errorf("Expected logged messages to be '%v' but got '%v'", expected, logged)
+	}
+}
+
+func TestUnmarshalWithOptionsCaseInsensitiveRemaining(t *testing.T) {
+	type MixedCaseStruct struct {
+		Port int    `env:"Port"`
+		Host string `env:"Host"`
+	}
+
+	for _, key := range []string{"PORT", "port"} {
+		environ := map[string]string{
+			key:     "8080",
+			"host":  "example.com",
+			"OTHER": "other",
+		}
+
+		var mixedCaseStruct MixedCaseStruct
+		err := UnmarshalWithOptions(environ, &mixedCaseStruct, Options{CaseInsensitive: true})
+		if err != nil {
+			t.Errorf("Expected no error but got '%s'", err)
+		}
+
+		if mixedCaseStruct.Port != 8080 {
+			t.Errorf("Expected field value to be '%d' but got '%d'", 8080, mixedCaseStruct.Port)
+		}
+
+		expected := map[string]string{"OTHER": "other"}
+		if !reflect.DeepEqual(environ, expected) {
+			t.Errorf("Expected remaining environ to be '%v' but got '%v'", expected, environ)
+		}
+	}
+
+	environ := map[string]string{
+		"PORT": "8080",
+	}
+
+	var mixedCaseStruct MixedCaseStruct
+	err := Unmarshal(environ, &mixedCaseStruct)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if mixedCaseStruct.Port != 0 {
+		t.Errorf("Expected field value to be '%d' but got '%d'", 0, mixedCaseStruct.Port)
+	}
+
+	if _, ok := environ["PORT"]; !ok {
+		t.Errorf("Expected field '%s' to exist but missing", "PORT")
+	}
+}
+
+func TestWithOptionsTagNameNested(t *testing.T) {
+	type CfgDB struct {
+		Port int    `cfg:"PORT"`
+		Host string `env:"HOST"`
+	}
+	type CfgNested struct {
+		DB     CfgDB  `cfg:"DB_,prefix"`
+		Cache  *CfgDB `cfg:"CACHE_,prefix"`
+		Inline CfgDB
+	}
+
+	environ := map[string]string{
+		"DB_PORT":    "5432",
+		"CACHE_PORT": "6379",
+		"PORT":       "8080",
+		"HOST":       "example.com",
+	}
+
+	var cfgNested CfgNested
+	err := UnmarshalWithOptions(environ, &cfgNested, Options{TagName: "cfg"})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := CfgNested{
+		DB:     CfgDB{Port: 5432},
+		Cache:  &CfgDB{Port: 6379},
+		Inline: CfgDB{Port: 8080},
+	}
+	if !reflect.DeepEqual(cfgNested, expected) {
+		t.Errorf("Expected field value to be '%v' but got '%v'", expected, cfgNested)
+	}
+
+	es, err := MarshalWithOptions(&cfgNested, Options{TagName: "cfg"})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expectedEs := EnvSet{
+		"DB_PORT":    "5432",
+		"CACHE_PORT": "6379",
+		"PORT":       "8080",
+	}
+	if !reflect.DeepEqual(es, expectedEs) {
+		t.Errorf("Expected field value to be '%v' but got '%v'", expectedEs, es)
+	}
+}
+
+func TestUnmarshalWithOptionsMaxValueLen(t *testing.T) {
+	environ := map[string]string{
+		"HOME":         "/home/test",
+		"SLICE_STRING": "a,b",
+	}
+
+	var validStruct ValidStruct
+	err := UnmarshalWithOptions(environ, &validStruct, Options{MaxValueLen: 10})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if validStruct.Home != "/home/test" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "/home/test", validStruct.Home)
+	}
+
+	environ = map[string]string{
+		"HOME": "/home/test/too/long",
+	}
+	err = UnmarshalWithOptions(environ, &validStruct, Options{MaxValueLen: 10})
+	if !errors.Is(err, ErrValueTooLong) {
+		t.Errorf("Expected error 'ErrValueTooLong' but got '%v'", err)
+	}
+
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "HOME" {
+		t.Errorf("Expected error '*FieldError' for key '%s' but got '%v'", "HOME", err)
+	}
+
+	environ = map[string]string{
+		"ARG_1": "short",
+		"ARG_2": "much too long",
+	}
+	var indexedStruct IndexedStruct
+	err = UnmarshalWithOptions(environ, &indexedStruct, Options{MaxValueLen: 10})
+	if !errors.Is(err, ErrValueTooLong) {
+		t.Errorf("Expected error 'ErrValueTooLong' but got '%v'", err)
+	}
+
+	// a default too long is reported under the primary key, not the last
+	// alias tried
+	environ = map[string]string{
+		"CACHE_URL": "r",
+	}
+	var aliasStruct AliasStruct
+	err = UnmarshalWithOptions(environ, &aliasStruct, Options{MaxValueLen: 3})
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "PORT" {
+		t.Errorf("Expected error '*FieldError' for key '%s' but got '%v'", "PORT", err)
+	}
+}
+
+func TestUnmarshalWithOptionsExpandNested(t *testing.T) {
+	environ := map[string]string{
+		"DOMAIN":   "example.com",
+		"HOST":     "api.${DOMAIN}",
+		"ORIGIN":   "https://$HOST",
+		"BASE_URL": "${ORIGIN}/v1",
+	}
+
+	var expandStruct ExpandStruct
+	err := UnmarshalWithOptions(environ, &expandStruct, Options{Expand: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if expandStruct.Host != "api.example.com" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "api.example.com", expandStruct.Host)
+	}
+
+	if expandStruct.BaseURL != "https://api.example.com/v1" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "https://api.example.com/v1", expandStruct.BaseURL)
+	}
+}
+
+func TestUnmarshalWithOptionsExpandEscaped(t *testing.T) {
+	environ := map[string]string{
+		"HOST":     "example.com",
+		"BASE_URL": "https://$HOST/$$HOST/cost$$5",
+	}
+
+	var expandStruct ExpandStruct
+	err := UnmarshalWithOptions(environ, &expandStruct, Options{Expand: true, ExpandStrict: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if expandStruct.BaseURL != "https://example.com/$HOST/cost$5" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "https://example.com/$HOST/cost$5", expandStruct.BaseURL)
+	}
+}
+
+func TestUnmarshalWithOptionsLooseBools(t *testing.T) {
+	for _, tt := range []struct {
+		value    string
+		expected bool
+	}{
+		{"true", true},
+		{"1", true},
+		{"T", true},
+		{"yes", true},
+		{"YES", true},
+		{"y", true},
+		{"on", true},
+		{"On", true},
+		{"enabled", true},
+		{"false", false},
+		{"0", false},
+		{"F", false},
+		{"no", false},
+		{"N", false},
+		{"off", false},
+		{"OFF", false},
+		{"disabled", false},
+	} {
+		environ := map[string]string{
+			"BOOL": tt.value,
+		}
+
+		validStruct := ValidStruct{Bool: !tt.expected}
+		err := UnmarshalWithOptions(environ, &validStruct, Options{LooseBools: true})
+		if err != nil {
+			t.Errorf("Expected no error for '%s' but got '%s'", tt.value, err)
+		}
+
+		if validStruct.Bool != tt.expected {
+			t.Errorf("Expected field value for '%s' to be '%t' but got '%t'", tt.value, tt.expected, validStruct.Bool)
+		}
+	}
+
+	for _, value := range []string{"maybe", ""} {
+		environ := map[string]string{
+			"BOOL": value,
+		}
+
+		var validStruct ValidStruct
+		err := UnmarshalWithOptions(environ, &validStruct, Options{LooseBools: true})
+		if _, ok := err.(*strconv.NumError); !ok {
+			t.Errorf("Expected error '*strconv.NumError' for '%s' but got '%v'", value, err)
+		}
+	}
+
+	environ := map[string]string{
+		"BOOL": "yes",
+	}
+
+	var validStruct ValidStruct
+	err := UnmarshalWithOptions(environ, &validStruct, Options{})
+	if _, ok := err.(*strconv.NumError); !ok {
+		t.Errorf("Expected error '*strconv.NumError' but got '%v'", err)
+	}
+}
+
+func TestUnmarshalWithOptionsLooseBoolsSlice(t *testing.T) {
+	environ := map[string]string{
+		"FLAGS": "yes,off,Y",
+	}
+
+	var boolSliceStruct BoolSliceStruct
+	err := UnmarshalWithOptions(environ, &boolSliceStruct, Options{LooseBools: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	flags := []bool{true, false, true}
+	if !reflect.DeepEqual(boolSliceStruct.Flags, flags) {
+		t.Errorf("Expected field value to be '%t' but got '%t'", flags, boolSliceStruct.Flags)
+	}
+
+	environ = map[string]string{
+		"FLAGS": "yes,maybe",
+	}
+
+	err = UnmarshalWithOptions(environ, &BoolSliceStruct{}, Options{LooseBools: true})
+	var numErr *strconv.NumError
+	if !errors.As(err, &numErr) {
+		t.Errorf("Expected error '*strconv.NumError' but got '%v'", err)
+	}
+}
+
+func TestWithOptionsLooseBoolsMarshal(t *testing.T) {
+	environ := map[string]string{
+		"FEATURES": "search=yes,beta=off",
+	}
+
+	var boolMapStruct BoolMapStruct
+	err := UnmarshalWithOptions(environ, &boolMapStruct, Options{LooseBools: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	es, err := MarshalWithOptions(&boolMapStruct, Options{LooseBools: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if es["FEATURES"] != "beta=false,search=true" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "beta=false,search=true", es["FEATURES"])
+	}
+}
+
+func TestUnmarshalWithOptionsStrictPrefix(t *testing.T) {
+	type StrictStruct struct {
+		DatabaseURL string `env:"APP_DATABASE_URL"`
+		Port        int    `env:"APP_PORT"`
+	}
+
+	environ := map[string]string{
+		"APP_DATABSE_URL": "postgres://localhost",
+		"APP_PROT":        "8080",
+		"APP_PORT":        "8080",
+		"HOME":            "/home/test",
+	}
+
+	var strictStruct StrictStruct
+	err := UnmarshalWithOptions(environ, &strictStruct, Options{StrictPrefix: "APP_"})
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected error 'ErrUnknownKey' but got '%v'", err)
+	}
+
+	expected := "APP_DATABSE_URL: key doesn't match any field\nAPP_PROT: key doesn't match any field"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%s' but got '%v'", expected, err)
+	}
+
+	if strictStruct.Port != 8080 {
+		t.Errorf("Expected field value to be '%d' but got '%d'", 8080, strictStruct.Port)
+	}
+
+	environ = map[string]string{
+		"APP_DATABASE_URL": "postgres://localhost",
+		"HOME":             "/home/test",
+	}
+	err = UnmarshalWithOptions(environ, &strictStruct, Options{StrictPrefix: "APP_"})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+}
+
+func TestUnmarshalFromEnvironWithOptions(t *testing.T) {
+	t.Setenv("GO_ENV_TEST_PORT", "8080")
+	t.Setenv("GO_ENV_TEST_PROT", "8080")
+
+	type EnvironStruct struct {
+		Port int `env:"GO_ENV_TEST_PORT"`
+	}
+
+	var environStruct EnvironStruct
+	es, err := UnmarshalFromEnvironWithOptions(&environStruct, Options{StrictPrefix: "GO_ENV_TEST_"})
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "GO_ENV_TEST_PROT" {
+		t.Errorf("Expected error '*FieldError' for key '%s' but got '%v'", "GO_ENV_TEST_PROT", err)
+	}
+
+	if environStruct.Port != 8080 {
+		t.Errorf("Expected field value to be '%d' but got '%d'", 8080, environStruct.Port)
+	}
+
+	if _, ok := es["GO_ENV_TEST_PORT"]; ok {
+		t.Errorf("Expected field '%s' to not exist", "GO_ENV_TEST_PORT")
+	}
+}
+
+func TestWithOptionsAutoName(t *testing.T) {
+	type AutoNameStruct struct {
+		MaxConnections int
+		HTTPPort       int
+		UserID         string
+		Host           string        `env:"SERVER_HOST"`
+		Timeout        time.Duration `env:",default=5s"`
+		Secret         string        `env:"-"`
+		DB             DBConfig      `env:"DB_,prefix"`
+		StartedAt      time.Time
+		Total          big.Int
+		internal       string
+	}
+
+	environ := map[string]string{
+		"MAX_CONNECTIONS": "10",
+		"HTTP_PORT":       "8080",
+		"USER_ID":         "42",
+		"SERVER_HOST":     "example.com",
+		"HOST":            "ignored",
+		"SECRET":          "ignored",
+		"DB_HOST":         "db.example.com",
+		"STARTED_AT":      "2020-01-02T03:04:05Z",
+		"TOTAL":           "123456789012345678901234567890",
+		"INTERNAL":        "ignored",
+	}
+
+	var autoNameStruct AutoNameStruct
+	err := UnmarshalWithOptions(environ, &autoNameStruct, Options{AutoName: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := AutoNameStruct{
+		MaxConnections: 10,
+		HTTPPort:       8080,
+		UserID:         "42",
+		Host:           "example.com",
+		Timeout:        5 * time.Second,
+		DB:             DBConfig{Host: "db.example.com"},
+		StartedAt:      time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	expected.Total.SetString("123456789012345678901234567890", 10)
+	if !reflect.DeepEqual(autoNameStruct, expected) {
+		t.Errorf("Expected field value to be '%v' but got '%v'", expected, autoNameStruct)
+	}
+
+	es, err := MarshalWithOptions(&autoNameStruct, Options{AutoName: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expectedEs := EnvSet{
+		"MAX_CONNECTIONS": "10",
+		"HTTP_PORT":       "8080",
+		"USER_ID":         "42",
+		"SERVER_HOST":     "example.com",
+		"TIMEOUT":         "5s",
+		"DB_HOST":         "db.example.com",
+		"DB_PORT":         "0",
+		"STARTED_AT":      "2020-01-02T03:04:05Z",
+		"TOTAL":           "123456789012345678901234567890",
+	}
+	if !reflect.DeepEqual(es, expectedEs) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", expectedEs, es)
+	}
+}
+
+func TestScreamingSnake(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Port":           "PORT",
+		"MaxConnections": "MAX_CONNECTIONS",
+		"HTTPPort":       "HTTP_PORT",
+		"UserID":         "USER_ID",
+		"ID":             "ID",
+		"APIKeyV2":       "API_KEY_V2",
+		"Port2Host":      "PORT2_HOST",
+	} {
+		if v := screamingSnake(name); v != expected {
+			t.Errorf("Expected key for '%s' to be '%s' but got '%s'", name, expected, v)
+		}
+	}
+}
+
+func TestUnmarshalWithOptionsAutoSlice(t *testing.T) {
+	for value, expected := range map[string][]string{
+		`["a,b", "c"]`: {"a,b", "c"},
+		` ["a"] `:      {"a"},
+		"a,b,c":        {"a", "b", "c"},
+	} {
+		environ := map[string]string{
+			"SLICE_STRING": value,
+		}
+
+		var validStruct ValidStruct
+		err := UnmarshalWithOptions(environ, &validStruct, Options{AutoSlice: true})
+		if err != nil {
+			t.Errorf("Expected no error but got '%s'", err)
+		}
+
+		if !reflect.DeepEqual(validStruct.SliceString, expected) {
+			t.Errorf("Expected field value to be '%s' but got '%s'", expected, validStruct.SliceString)
+		}
+	}
+
+	environ := map[string]string{
+		"SLICE_INT": "[1, 2, 3]",
+	}
+
+	validStruct := ValidStruct{SliceInt: []int{0}}
+	err := UnmarshalWithOptions(environ, &validStruct, Options{AutoSlice: true, AppendSlices: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	intSlice := []int{0, 1, 2, 3}
+	if !reflect.DeepEqual(validStruct.SliceInt, intSlice) {
+		t.Errorf("Expected field value to be '%d' but got '%d'", intSlice, validStruct.SliceInt)
+	}
+
+	environ = map[string]string{
+		"SLICE_INT": "[1, 2",
+	}
+	err = UnmarshalWithOptions(environ, &validStruct, Options{AutoSlice: true})
+	if _, ok := err.(*json.SyntaxError); !ok {
+		t.Errorf("Expected error '*json.SyntaxError' but got '%v'", err)
+	}
+}
+
+func TestMarshalWithOptionsMarshalKey(t *testing.T) {
+	type MarshalKeyStruct struct {
+		Port int      `env:"PORT"`
+		DB   DBConfig `env:"DB_,prefix"`
+		Args []string `env:"ARG,indexed"`
+	}
+
+	marshalKeyStruct := MarshalKeyStruct{
+		Port: 8080,
+		DB:   DBConfig{Host: "db.example.com", Port: 5432},
+		Args: []string{"a", "b"},
+	}
+
+	es, err := MarshalWithOptions(&marshalKeyStruct, Options{MarshalKey: strings.ToLower})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := EnvSet{
+		"port":    "8080",
+		"db_host": "db.example.com",
+		"db_port": "5432",
+		"arg_1":   "a",
+		"arg_2":   "b",
+	}
+	if !reflect.DeepEqual(es, expected) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", expected, es)
+	}
+}
+
+func TestUnmarshalWithOptionsTrimSpace(t *testing.T) {
+	environ := map[string]string{
+		"INT":          " 8080 ",
+		"SLICE_STRING": " a , b ,c",
+		"SLICE_INT":    "1, 2 , 3",
+	}
+
+	var validStruct ValidStruct
+	err := UnmarshalWithOptions(environ, &validStruct, Options{TrimSpace: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if validStruct.Int != 8080 {
+		t.Errorf("Expected field value to be '%d' but got '%d'", 8080, validStruct.Int)
+	}
+
+	// string elements keep their whitespace without the "trim" tag option
+	stringSlice := []string{" a ", " b ", "c"}
+	if !reflect.DeepEqual(validStruct.SliceString, stringSlice) {
+		t.Errorf("Expected field value to be '%s' but got '%s'", stringSlice, validStruct.SliceString)
+	}
+
+	intSlice := []int{1, 2, 3}
+	if !reflect.DeepEqual(validStruct.SliceInt, intSlice) {
+		t.Errorf("Expected field value to be '%d' but got '%d'", intSlice, validStruct.SliceInt)
+	}
+
+	environ = map[string]string{
+		"LIMITS": "cpu= 2 ,mem=512 ",
+	}
+
+	var mapStruct MapStruct
+	err = UnmarshalWithOptions(environ, &mapStruct, Options{TrimSpace: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	limits := map[string]int{"cpu": 2, "mem": 512}
+	if !reflect.DeepEqual(mapStruct.Limits, limits) {
+		t.Errorf("Expected field value to be '%v' but got '%v'", limits, mapStruct.Limits)
+	}
+
+	environ = map[string]string{
+		"INT": " 8080 ",
+	}
+	err = UnmarshalWithOptions(environ, &validStruct, Options{})
+	if _, ok := err.(*strconv.NumError); !ok {
+		t.Errorf("Expected error '*strconv.NumError' but got '%v'", err)
+	}
+}
+
+func TestUnmarshalWithOptionsTrimSpaceStrings(t *testing.T) {
+	environ := map[string]string{
+		"NAME":           "  my app ",
+		"TRIMMED":        " my app  ",
+		"POINTER":        " padded ",
+		"HOSTS":          " a , b ",
+		"LABELS":         " env = prod , team=core ",
+		"TRIMMED_LABELS": " env = prod , team=core ",
+	}
+
+	var trimStruct struct {
+		Name          string            `env:"NAME"`
+		Trimmed       string            `env:"TRIMMED,trim"`
+		Pointer       *string           `env:"POINTER"`
+		Hosts         []string          `env:"HOSTS"`
+		Labels        map[string]string `env:"LABELS"`
+		TrimmedLabels map[string]string `env:"TRIMMED_LABELS,trim"`
+	}
+	err := UnmarshalWithOptions(environ, &trimStruct, Options{TrimSpace: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if trimStruct.Name != "  my app " {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "  my app ", trimStruct.Name)
+	}
+	if trimStruct.Trimmed != "my app" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "my app", trimStruct.Trimmed)
+	}
+	if trimStruct.Pointer == nil || *trimStruct.Pointer != " padded " {
+		t.Errorf("Expected field value to be '%s' but got '%v'", " padded ", trimStruct.Pointer)
+	}
+
+	hosts := []string{" a ", " b "}
+	if !reflect.DeepEqual(trimStruct.Hosts, hosts) {
+		t.Errorf("Expected field value to be '%q' but got '%q'", hosts, trimStruct.Hosts)
+	}
+
+	labels := map[string]string{" env ": " prod ", " team": "core "}
+	if !reflect.DeepEqual(trimStruct.Labels, labels) {
+		t.Errorf("Expected field value to be '%q' but got '%q'", labels, trimStruct.Labels)
+	}
+
+	trimmedLabels := map[string]string{"env": "prod", "team": "core"}
+	if !reflect.DeepEqual(trimStruct.TrimmedLabels, trimmedLabels) {
+		t.Errorf("Expected field value to be '%v' but got '%v'", trimmedLabels, trimStruct.TrimmedLabels)
+	}
+}
+
+func TestUnmarshalTrimTag(t *testing.T) {
+	environ := map[string]string{
+		"PORT":  " 8080 ",
+		"HOSTS": " a , b ",
+	}
+
+	var trimStruct struct {
+		Port  int      `env:"PORT,trim"`
+		Hosts []string `env:"HOSTS,trim"`
+	}
+	err := Unmarshal(environ, &trimStruct)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if trimStruct.Port != 8080 {
+		t.Errorf("Expected field value to be '%d' but got '%d'", 8080, trimStruct.Port)
+	}
+
+	hosts := []string{"a", "b"}
+	if !reflect.DeepEqual(trimStruct.Hosts, hosts) {
+		t.Errorf("Expected field value to be '%s' but got '%s'", hosts, trimStruct.Hosts)
+	}
+}
+
+func TestUnmarshalWithOptionsURLDecodeValues(t *testing.T) {
+	environ := map[string]string{
+		"STRING": "hello+world%21",
+		"INT":    "%2D42",
+		"HOSTS":  "a%2Cb,c",
+		"ARG_1":  "x%3Dy",
+	}
+
+	var urlStruct struct {
+		String string   `env:"STRING"`
+		Int    int      `env:"INT"`
+		Hosts  []string `env:"HOSTS"`
+		Args   []string `env:"ARG,indexed"`
+		Plain  string   `env:"PLAIN,default=a+b"`
+	}
+	err := UnmarshalWithOptions(environ, &urlStruct, Options{URLDecodeValues: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if urlStruct.String != "hello world!" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "hello world!", urlStruct.String)
+	}
+	if urlStruct.Int != -42 {
+		t.Errorf("Expected field value to be '%d' but got '%d'", -42, urlStruct.Int)
+	}
+	if !reflect.DeepEqual(urlStruct.Hosts, []string{"a", "b", "c"}) {
+		t.Errorf("Expected field value to be '%v' but got '%v'", []string{"a", "b", "c"}, urlStruct.Hosts)
+	}
+	if !reflect.DeepEqual(urlStruct.Args, []string{"x=y"}) {
+		t.Errorf("Expected field value to be '%v' but got '%v'", []string{"x=y"}, urlStruct.Args)
+	}
+	if urlStruct.Plain != "a+b" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "a+b", urlStruct.Plain)
+	}
+
+	environ = map[string]string{
+		"STRING": "100%",
+	}
+	err = UnmarshalWithOptions(environ, &urlStruct, Options{URLDecodeValues: true})
+	var fieldErr *FieldError
+	var escapeErr url.EscapeError
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "STRING" || !errors.As(err, &escapeErr) {
+		t.Errorf("Expected error 'url.EscapeError' for key '%s' but got '%v'", "STRING", err)
+	}
+}
+
+func TestMultiCharacterSeparator(t *testing.T) {
+	environ := map[string]string{
+		"HOSTS":  "a | b | c",
+		"PORTS":  "80::443",
+		"LABELS": "env=prod | team=core",
+	}
+
+	var separatorStruct struct {
+		Hosts  []string          `env:"HOSTS,separator= | "`
+		Ports  []int             `env:"PORTS,separator=::"`
+		Labels map[string]string `env:"LABELS,separator= | "`
+	}
+	err := Unmarshal(environ, &separatorStruct)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	hosts := []string{"a", "b", "c"}
+	if !reflect.DeepEqual(separatorStruct.Hosts, hosts) {
+		t.Errorf("Expected field value to be '%s' but got '%s'", hosts, separatorStruct.Hosts)
+	}
+
+	ports := []int{80, 443}
+	if !reflect.DeepEqual(separatorStruct.Ports, ports) {
+		t.Errorf("Expected field value to be '%d' but got '%d'", ports, separatorStruct.Ports)
+	}
+
+	labels := map[string]string{"env": "prod", "team": "core"}
+	if !reflect.DeepEqual(separatorStruct.Labels, labels) {
+		t.Errorf("Expected field value to be '%v' but got '%v'", labels, separatorStruct.Labels)
+	}
+
+	es, err := Marshal(&separatorStruct)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+	expected := EnvSet{
+		"HOSTS":  "a | b | c",
+		"PORTS":  "80::443",
+		"LABELS": "env=prod | team=core",
+	}
+	if !reflect.DeepEqual(es, expected) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", expected, es)
+	}
+}
+
+func TestWithOptionsMultiCharacterSeparator(t *testing.T) {
+	environ := map[string]string{
+		"SLICE_STRING": "a, b,  c ,d",
+		"SLICE_INT":    "1, 2",
+	}
+
+	var validStruct ValidStruct
+	err := UnmarshalWithOptions(environ, &validStruct, Options{Separator: ", ", TrimSpace: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	// " c ,d" has no ", " so it stays one element, untrimmed as a string
+	stringSlice := []string{"a", "b", " c ,d"}
+	if !reflect.DeepEqual(validStruct.SliceString, stringSlice) {
+		t.Errorf("Expected field value to be '%s' but got '%s'", stringSlice, validStruct.SliceString)
+	}
+
+	intSlice := []int{1, 2}
+	if !reflect.DeepEqual(validStruct.SliceInt, intSlice) {
+		t.Errorf("Expected field value to be '%d' but got '%d'", intSlice, validStruct.SliceInt)
+	}
+
+	es, err := MarshalWithOptions(&validStruct, Options{Separator: ", "})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+	if es["SLICE_STRING"] != "a, b,  c ,d" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "a, b,  c ,d", es["SLICE_STRING"])
+	}
+}
+
+func TestMarshalWithOptionsOmitEmpty(t *testing.T) {
+	var port int
+	validStruct := ValidStruct{
+		Home:        "/home/test",
+		Int:         8080,
+		SliceString: []string{"a"},
+		PointerInt:  &port,
+	}
+
+	es, err := MarshalWithOptions(&validStruct, Options{OmitEmpty: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	for k, v := range map[string]string{"HOME": "/home/test", "INT": "8080", "SLICE_STRING": "a", "POINTER_INT": "0"} {
+		if es[k] != v {
+			t.Errorf("Expected field value to be '%s' but got '%s'", v, es[k])
+		}
+	}
+
+	for _, k := range []string{"BOOL", "FLOAT32", "FLOAT64", "SLICE_INT", "POINTER_STRING", "WORKSPACE"} {
+		if _, ok := es[k]; ok {
+			t.Errorf("Expected key '%s' to be omitted but got '%s'", k, es[k])
+		}
+	}
+
+	es, err = MarshalWithOptions(&validStruct, Options{})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if es["BOOL"] != "false" {
+		t.Errorf("Expected field value to be '%s' but got '%s'", "false", es["BOOL"])
+	}
+}
+
+func TestUnmarshalWithOptionsValidateDefaults(t *testing.T) {
+	type defaultsStruct struct {
+		Workers int  `env:"WORKERS,default=abc"`
+		Debug   bool `env:"DEBUG,defaultIf=ENV==dev:maybe"`
+	}
+
+	environ := map[string]string{
+		"WORKERS": "8",
+		"DEBUG":   "true",
+	}
+
+	var validStruct defaultsStruct
+	err := UnmarshalWithOptions(environ, &validStruct, Options{})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if validStruct.Workers != 8 {
+		t.Errorf("Expected field value to be '%d' but got '%d'", 8, validStruct.Workers)
+	}
+
+	environ = map[string]string{
+		"WORKERS": "8",
+		"DEBUG":   "true",
+	}
+
+	err = UnmarshalWithOptions(environ, &defaultsStruct{}, Options{ValidateDefaults: true, CollectErrors: true})
+	var numErr *strconv.NumError
+	if !errors.As(err, &numErr) {
+		t.Errorf("Expected error '*strconv.NumError' but got '%v'", err)
+	}
+
+	for _, expected := range []string{`WORKERS: default "abc"`, `DEBUG: default "maybe"`} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error '%s' but got '%v'", expected, err)
+		}
+	}
+
+	var goodDefaults struct {
+		Workers int `env:"WORKERS,default=4"`
+	}
+	err = UnmarshalWithOptions(map[string]string{}, &goodDefaults, Options{ValidateDefaults: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if goodDefaults.Workers != 4 {
+		t.Errorf("Expected field value to be '%d' but got '%d'", 4, goodDefaults.Workers)
+	}
+}
+
+func TestValidateWithOptions(t *testing.T) {
+	environ := map[string]string{
+		"database_url": "postgres://localhost",
+	}
+
+	var requiredStruct RequiredStruct
+	err := Validate(environ, &requiredStruct)
+	if !errors.Is(err, ErrMissingRequiredValue) {
+		t.Errorf("Expected error 'ErrMissingRequiredValue' but got '%v'", err)
+	}
+
+	err = ValidateWithOptions(environ, &requiredStruct, Options{CaseInsensitive: true})
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if _, ok := environ["database_url"]; !ok {
+		t.Errorf("Expected field '%s' to exist but missing", "database_url")
+	}
+
+	environ = map[string]string{
+		"DATABASE_URL": "postgres://localhost",
+		"DATABASE_URI": "postgres://typo",
+	}
+	err = ValidateWithOptions(environ, &requiredStruct, Options{StrictPrefix: "DATABASE_"})
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected error 'ErrUnknownKey' but got '%v'", err)
+	}
+
+	err = ValidateWithOptions(environ, requiredStruct, Options{})
+	if err != ErrInvalidValue {
+		t.Errorf("Expected error 'ErrInvalidValue' but got '%v'", err)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 Netflix, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package env
+
+import (
+	"reflect"
+)
+
+// Overlay copies the fields of override that hold non-zero values onto base,
+// layering configuration without going through an EnvSet. Both must be
+// non-nil pointers to structs of the same type, otherwise Overlay returns
+// ErrInvalidValue.
+//
+// Only fields tagged with "env" are copied, and fields tagged `env:"-"` are
+// ignored. Untagged nested structs, and those with the "prefix" tag option,
+// are overlaid field by field, as are non-nil pointers to them, so a partially
+// set nested struct in override doesn't clear the rest of the nested struct
+// in base. A nil pointer to a struct in base is allocated if override has
+// fields to copy into it.
+func Overlay(base interface{}, override interface{}) error {
+	bv := reflect.ValueOf(base)
+	ov := reflect.ValueOf(override)
+	if bv.Kind() != reflect.Ptr || bv.IsNil() || ov.Kind() != reflect.Ptr || ov.IsNil() {
+		return ErrInvalidValue
+	}
+
+	bv = bv.Elem()
+	ov = ov.Elem()
+	if bv.Kind() != reflect.Struct || bv.Type() != ov.Type() {
+		return ErrInvalidValue
+	}
+
+	overlay(bv, ov)
+	return nil
+}
+
+// overlay copies the non-zero tagged fields of the struct ov onto bv.
+func overlay(bv, ov reflect.Value) {
+	t := bv.Type()
+	for i := 0; i < bv.NumField(); i++ {
+		baseField := bv.Field(i)
+		overrideField := ov.Field(i)
+		typeField := t.Field(i)
+		tag := parseTag(typeField.Tag.Get("env"))
+		if tag.skip() {
+			continue
+		}
+
+		// traverse untagged and prefixed nested structs, but copy other
+		// tagged structs, such as time.Time, as a whole
+		_, prefixed := tag.option("prefix")
+		if isStruct(typeField.Type) && (tag.key == "" || prefixed) {
+			if isTraversable(typeField) {
+				overlayStruct(baseField, overrideField)
+			}
+			continue
+		}
+
+		if tag.key == "" || !baseField.CanSet() || overrideField.IsZero() {
+			continue
+		}
+		baseField.Set(overrideField)
+	}
+}
+
+// overlayStruct overlays the struct, or pointer to struct, of.
+func overlayStruct(bf, of reflect.Value) {
+	if bf.Kind() != reflect.Ptr {
+		overlay(bf, of)
+		return
+	}
+
+	if of.IsNil() {
+		return
+	}
+	if bf.IsNil() {
+		if !bf.CanSet() {
+			return
+		}
+		bf.Set(reflect.New(bf.Type().Elem()))
+	}
+	overlay(bf.Elem(), of.Elem())
+}
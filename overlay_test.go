@@ -0,0 +1,96 @@
+// Copyright 2018 Netflix, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package env
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type OverlayStruct struct {
+	Host    string    `env:"HOST"`
+	Port    int       `env:"PORT"`
+	Debug   bool      `env:"DEBUG"`
+	Started time.Time `env:"STARTED"`
+	Secret  string    `env:"-"`
+	Extra   string
+
+	DB    DBConfig  `env:"DB_,prefix"`
+	Cache *DBConfig `env:"CACHE_,prefix"`
+}
+
+func TestOverlay(t *testing.T) {
+	base := OverlayStruct{
+		Host:   "localhost",
+		Port:   8080,
+		Secret: "base",
+		Extra:  "base",
+		DB:     DBConfig{Host: "db.local", Port: 5432},
+	}
+
+	started := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	override := OverlayStruct{
+		Port:    9090,
+		Debug:   true,
+		Started: started,
+		Secret:  "override",
+		Extra:   "override",
+		DB:      DBConfig{Host: "db.example.com"},
+		Cache:   &DBConfig{Port: 6379},
+	}
+
+	err := Overlay(&base, &override)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := OverlayStruct{
+		Host:    "localhost",
+		Port:    9090,
+		Debug:   true,
+		Started: started,
+		Secret:  "base",
+		Extra:   "base",
+		DB:      DBConfig{Host: "db.example.com", Port: 5432},
+		Cache:   &DBConfig{Port: 6379},
+	}
+	if !reflect.DeepEqual(base, expected) {
+		t.Errorf("Expected field value to be '%v' but got '%v'", expected, base)
+	}
+
+	if base.Cache == override.Cache {
+		t.Errorf("Expected pointer to be allocated rather than shared")
+	}
+}
+
+func TestOverlayInvalid(t *testing.T) {
+	var base OverlayStruct
+	var other ValidStruct
+
+	err := Overlay(&base, &other)
+	if err != ErrInvalidValue {
+		t.Errorf("Expected error 'ErrInvalidValue' but got '%v'", err)
+	}
+
+	err = Overlay(&base, base)
+	if err != ErrInvalidValue {
+		t.Errorf("Expected error 'ErrInvalidValue' but got '%v'", err)
+	}
+
+	err = Overlay(nil, &base)
+	if err != ErrInvalidValue {
+		t.Errorf("Expected error 'ErrInvalidValue' but got '%v'", err)
+	}
+}
@@ -0,0 +1,487 @@
+// Copyright 2018 Netflix, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package env
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// fieldTag is a parsed "env" struct field tag. The first comma-separated item
+// is the environment variable key; any following items are options, either
+// flags like "required" or key/value pairs like "layout=2006-01-02", or alias
+// keys like "REDIS_URL" that are looked up in order if the key is missing.
+type fieldTag struct {
+	key     string
+	aliases []string
+	options map[string]string
+
+	// malformed is set if an item is empty, or looks like an option flag but
+	// isn't one, e.g. a misspelled "requird"
+	malformed bool
+}
+
+// flagOptions holds the options that take no value, which distinguishes them
+// from alias keys.
+var flagOptions = map[string]bool{
+	"required":    true,
+	"prefix":      true,
+	"indexed":     true,
+	"json":        true,
+	"requireSign": true,
+	"omitempty":   true,
+	"trim":        true,
+}
+
+func parseTag(s string) fieldTag {
+	parts := strings.Split(s, ",")
+	tag := fieldTag{
+		key:     parts[0],
+		options: make(map[string]string),
+	}
+	for _, option := range parts[1:] {
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) == 2 {
+			tag.options[kv[0]] = kv[1]
+		} else if flagOptions[option] {
+			tag.options[option] = ""
+		} else if option == "" || isFlagLike(option) {
+			tag.malformed = true
+		} else {
+			tag.aliases = append(tag.aliases, option)
+		}
+	}
+	return tag
+}
+
+// isFlagLike reports whether item is spelled like an option flag, only letters
+// starting with a lowercase one, e.g. "required" or "requireSign", rather than
+// like an alias key.
+func isFlagLike(item string) bool {
+	for i, r := range item {
+		if !unicode.IsLetter(r) || (i == 0 && !unicode.IsLower(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// tagOf parses the tag of field named by opts.TagName, or "env" by default.
+// If set, opts.Separator replaces the default separator of the tag. If
+// opts.AutoName is set, an exported field without a key is given one derived
+// from its name, unless it's a nested struct.
+func tagOf(field reflect.StructField, opts Options) fieldTag {
+	name := opts.TagName
+	if name == "" {
+		name = "env"
+	}
+
+	tag := parseTag(field.Tag.Get(name))
+	if _, ok := tag.option("separator"); !ok && opts.Separator != "" {
+		tag.options["separator"] = opts.Separator
+	}
+
+	if opts.AutoName && tag.key == "" && field.IsExported() && !isNested(field.Type, tag) {
+		tag.key = screamingSnake(field.Name)
+	}
+	return tag
+}
+
+// tagCache holds the parsed tags of the fields of struct types, keyed by
+// tagCacheKey, so that each type is only parsed once. The cached tags are
+// shared, and must not be modified.
+var tagCache sync.Map
+
+// tagCacheKey identifies a struct type along with the options that affect how
+// its tags are parsed.
+type tagCacheKey struct {
+	typ       reflect.Type
+	tagName   string
+	separator string
+	autoName  bool
+}
+
+// tagsOf returns the parsed tags of the fields of the struct type t, in field
+// order, as tagOf parses them.
+func tagsOf(t reflect.Type, opts Options) []fieldTag {
+	key := tagCacheKey{
+		typ:       t,
+		tagName:   opts.TagName,
+		separator: opts.Separator,
+		autoName:  opts.AutoName,
+	}
+	if tags, ok := tagCache.Load(key); ok {
+		return tags.([]fieldTag)
+	}
+
+	tags := make([]fieldTag, t.NumField())
+	for i := range tags {
+		tags[i] = tagOf(t.Field(i), opts)
+	}
+	cached, _ := tagCache.LoadOrStore(key, tags)
+	return cached.([]fieldTag)
+}
+
+// isNested reports whether a field of type t with the tag tag is a struct, or
+// pointer to struct, that is traversed rather than parsed as a single value,
+// as isValueType reports for types such as time.Time or big.Int.
+func isNested(t reflect.Type, tag fieldTag) bool {
+	return isStruct(t) && !isValueType(t, tag)
+}
+
+// screamingSnake converts a Go field name to an environment variable key, e.g.
+// MaxConnections to MAX_CONNECTIONS. A run of capitals is kept together as an
+// acronym, so HTTPPort becomes HTTP_PORT.
+func screamingSnake(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// skip reports whether the field is tagged `env:"-"` and must be ignored.
+func (t fieldTag) skip() bool {
+	return t.key == "-" && len(t.options) == 0 && len(t.aliases) == 0
+}
+
+// keys returns the key of t followed by its aliases, in order of precedence.
+func (t fieldTag) keys() []string {
+	return append([]string{t.key}, t.aliases...)
+}
+
+// withPrefix returns t with prefix prepended to its key and aliases.
+func (t fieldTag) withPrefix(prefix string) fieldTag {
+	t.key = prefix + t.key
+	aliases := make([]string, len(t.aliases))
+	for i, alias := range t.aliases {
+		aliases[i] = prefix + alias
+	}
+	t.aliases = aliases
+	return t
+}
+
+// option returns the value of the named option and whether it was present.
+func (t fieldTag) option(name string) (string, bool) {
+	v, ok := t.options[name]
+	return v, ok
+}
+
+// validate reports whether the combination of options is valid for a field of
+// type typ.
+func (t fieldTag) validate(typ reflect.Type) error {
+	if t.malformed {
+		return t.invalid()
+	}
+
+	_, required := t.option("required")
+	_, hasDefault := t.option("default")
+	_, hasDefaultIf := t.option("defaultIf")
+	if required && (hasDefault || hasDefaultIf) {
+		return t.invalid()
+	}
+
+	_, hasConcat := t.option("concat")
+	if hasConcat && typ.Kind() != reflect.String {
+		return t.invalid()
+	}
+
+	_, indexed := t.option("indexed")
+	if indexed && (typ.Kind() != reflect.Slice || hasDefault || hasDefaultIf || hasConcat || len(t.aliases) > 0) {
+		return t.invalid()
+	}
+
+	if _, ok := t.option("encoding"); ok {
+		elem := typ
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if _, known := t.encoding(); !known || elem != bytesType {
+			return t.invalid()
+		}
+	}
+
+	_, hasLayout := t.option("layout")
+	if v, ok := t.option("layouts"); ok {
+		if hasLayout {
+			return t.invalid()
+		}
+		for _, layout := range strings.Split(v, "|") {
+			if layout == "" {
+				return t.invalid()
+			}
+		}
+	}
+
+	if _, ok := t.option("base"); ok {
+		if _, known := t.base(); !known {
+			return t.invalid()
+		}
+	}
+
+	if _, ok := t.option("durationAs"); ok {
+		if _, known := t.durationUnit(); !known {
+			return t.invalid()
+		}
+	}
+	return nil
+}
+
+// invalid returns ErrInvalidTag wrapped with the key of t.
+func (t fieldTag) invalid() error {
+	return &FieldError{Key: t.key, Err: ErrInvalidTag}
+}
+
+// defaultValue returns the value to use when the field's key is missing from
+// es, and whether there is one.
+//
+// The "concat" option builds the value from other keys in es; see concat.
+// The "defaultIf" option has the form "VAR==value:default" and applies default
+// only when VAR is set to value in es. Otherwise, the "default" option applies
+// unconditionally.
+func (t fieldTag) defaultValue(es EnvSet) (string, bool, error) {
+	if v, ok := t.option("concat"); ok {
+		value, err := t.concat(es, v)
+		if err != nil {
+			return "", false, err
+		}
+		return value, true, nil
+	}
+
+	if v, ok := t.option("defaultIf"); ok {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 {
+			return "", false, t.invalid()
+		}
+		cond := strings.SplitN(parts[0], "==", 2)
+		if len(cond) != 2 || cond[0] == "" {
+			return "", false, t.invalid()
+		}
+
+		if actual, ok := es[cond[0]]; ok && actual == cond[1] {
+			return parts[1], true, nil
+		}
+	}
+
+	v, ok := t.option("default")
+	return v, ok, nil
+}
+
+// defaults returns the values declared by the "default" option and by a well
+// formed "defaultIf" option, whatever its condition.
+func (t fieldTag) defaults() []string {
+	var defaults []string
+	if v, ok := t.option("defaultIf"); ok {
+		if parts := strings.SplitN(v, ":", 2); len(parts) == 2 {
+			defaults = append(defaults, parts[1])
+		}
+	}
+	if v, ok := t.option("default"); ok {
+		defaults = append(defaults, v)
+	}
+	return defaults
+}
+
+// separator returns the separator used to split and join slice values.
+func (t fieldTag) separator() string {
+	if v, ok := t.option("separator"); ok && v != "" {
+		return v
+	}
+	return ","
+}
+
+// hasBoolWords reports whether the "true" or "false" options declare words to
+// use for boolean values.
+func (t fieldTag) hasBoolWords() bool {
+	_, hasTrue := t.option("true")
+	_, hasFalse := t.option("false")
+	return hasTrue || hasFalse
+}
+
+// boolWord returns the word for b, as declared by the "true" and "false"
+// options, or as formatted by strconv.FormatBool by default.
+func (t fieldTag) boolWord(b bool) string {
+	if v, ok := t.option(strconv.FormatBool(b)); ok {
+		return v
+	}
+	return strconv.FormatBool(b)
+}
+
+// concat expands template, replacing each key it references with the value of
+// that key in es. A key is a run of letters, digits and underscores that does
+// not start with a digit; anything else is copied literally, so
+// "DB_USER:DB_PASS@DB_HOST" joins three keys with ":" and "@".
+//
+// A key missing from es is an error wrapping ErrMissingRequiredValue, unless
+// the "concatMissing=empty" option expands it to the empty string.
+func (t fieldTag) concat(es EnvSet, template string) (string, error) {
+	missing, _ := t.option("concatMissing")
+
+	var b strings.Builder
+	for i := 0; i < len(template); {
+		c := template[i]
+		if !isKeyStart(c) {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+
+		j := i + 1
+		for j < len(template) && (isKeyStart(template[j]) || isDigit(template[j])) {
+			j++
+		}
+		key := template[i:j]
+		i = j
+
+		v, ok := es[key]
+		if !ok && missing != "empty" {
+			return "", &FieldError{Key: t.key, Err: fmt.Errorf("%s: %w", key, ErrMissingRequiredValue)}
+		}
+		b.WriteString(v)
+	}
+	return b.String(), nil
+}
+
+func isKeyStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// encoding returns the base64 encoding named by the "encoding" option, and
+// whether the name is known. The names are "base64" for standard encoding,
+// which is the default, "base64url" for URL encoding, and "base64raw" and
+// "base64rawurl" for their unpadded forms.
+func (t fieldTag) encoding() (*base64.Encoding, bool) {
+	v, ok := t.option("encoding")
+	if !ok {
+		return base64.StdEncoding, true
+	}
+
+	switch v {
+	case "base64":
+		return base64.StdEncoding, true
+	case "base64url":
+		return base64.URLEncoding, true
+	case "base64raw":
+		return base64.RawStdEncoding, true
+	case "base64rawurl":
+		return base64.RawURLEncoding, true
+	default:
+		return nil, false
+	}
+}
+
+// durationUnit returns the unit named by the "durationAs" option, either "s"
+// for seconds or "ms" for milliseconds, and whether the name is known.
+func (t fieldTag) durationUnit() (time.Duration, bool) {
+	v, _ := t.option("durationAs")
+	switch v {
+	case "s":
+		return time.Second, true
+	case "ms":
+		return time.Millisecond, true
+	default:
+		return 0, false
+	}
+}
+
+// base returns the base named by the "base" option for parsing integers, 10
+// by default, and whether it's valid: 0 to detect the base from the value's
+// prefix, or 2 to 36.
+func (t fieldTag) base() (int, bool) {
+	v, ok := t.option("base")
+	if !ok {
+		return 10, true
+	}
+
+	base, err := strconv.Atoi(v)
+	if err != nil || base != 0 && (base < 2 || base > 36) {
+		return 0, false
+	}
+	return base, true
+}
+
+// formatBase returns the base used to format integers, which is base unless
+// it's 0, which formats in base 10.
+func (t fieldTag) formatBase() int {
+	if base, _ := t.base(); base != 0 {
+		return base
+	}
+	return 10
+}
+
+// namedLayouts holds the time layouts that may be given by the name of their
+// constant in the time package, which also allows layouts holding commas,
+// e.g. RFC1123.
+var namedLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RubyDate":    time.RubyDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+// layouts returns the time layouts used for time.Time fields, in the order
+// they're tried when parsing: those of the "layouts" option, separated by
+// "|", or else that of the "layout" option, or time.RFC3339 by default. A
+// layout may be the name of a constant of the time package, e.g. RFC3339.
+func (t fieldTag) layouts() []string {
+	var layouts []string
+	if v, ok := t.option("layouts"); ok {
+		layouts = strings.Split(v, "|")
+	} else if v, ok := t.option("layout"); ok {
+		layouts = []string{v}
+	} else {
+		return []string{time.RFC3339}
+	}
+
+	for i, layout := range layouts {
+		if named, ok := namedLayouts[layout]; ok {
+			layouts[i] = named
+		}
+	}
+	return layouts
+}
+
+// layout returns the time layout used to format time.Time fields, the first
+// of layouts.
+func (t fieldTag) layout() string {
+	return t.layouts()[0]
+}
@@ -16,6 +16,7 @@ package env
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -32,20 +33,74 @@ type EnvSet map[string]string
 type ChangeSet map[string]*string
 
 // Apply applies a ChangeSet to EnvSet, modifying its contents.
-func (e EnvSet) Apply(cs ChangeSet) {
+func (es EnvSet) Apply(cs ChangeSet) {
 	for k, v := range cs {
 		if v == nil {
 			// Equivalent to os.Unsetenv
-			delete(e, k)
+			delete(es, k)
 		} else {
 			// Equivalent to os.Setenv
-			e[k] = *v
+			es[k] = *v
 		}
 	}
 }
 
+// Diff compares es with other, typically an older and a newer set. It returns
+// the keys only in other as added, the keys only in es as removed, and the
+// keys in both with different values as changed, holding the values of other.
+func (es EnvSet) Diff(other EnvSet) (added, removed, changed map[string]string) {
+	added = make(map[string]string)
+	removed = make(map[string]string)
+	changed = make(map[string]string)
+	for k, v := range es {
+		o, ok := other[k]
+		if !ok {
+			removed[k] = v
+		} else if o != v {
+			changed[k] = o
+		}
+	}
+	for k, v := range other {
+		if _, ok := es[k]; !ok {
+			added[k] = v
+		}
+	}
+	return added, removed, changed
+}
+
+// MergeEnvSets returns a new EnvSet holding the keys of all sets, such as
+// baked-in defaults, then a .env file, then the process environment. A key in
+// a later set overrides the same key in earlier sets. The sets aren't
+// modified.
+func MergeEnvSets(sets ...EnvSet) EnvSet {
+	m := make(EnvSet)
+	for _, es := range sets {
+		for k, v := range es {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// Merge returns a new EnvSet holding the keys of es and other, where a key in
+// other overrides the same key in es. Neither set is modified.
+func (es EnvSet) Merge(other EnvSet) EnvSet {
+	return MergeEnvSets(es, other)
+}
+
+// Clone returns a copy of es, such as for unmarshalling the same set into
+// several structs, since Unmarshal deletes the keys it matches.
+func (es EnvSet) Clone() EnvSet {
+	m := make(EnvSet, len(es))
+	for k, v := range es {
+		m[k] = v
+	}
+	return m
+}
+
 // EnvironToEnvSet transforms a slice of string with the format "key=value" into
-// the corresponding EnvSet. If any item in environ does follow the format,
+// the corresponding EnvSet, splitting each item on its first "=". Values are
+// kept as is, quotes included. If any item in environ has no "=",
 // EnvironToEnvSet returns ErrInvalidEnviron.
 func EnvironToEnvSet(environ []string) (EnvSet, error) {
 	m := make(EnvSet)
@@ -59,6 +114,42 @@ func EnvironToEnvSet(environ []string) (EnvSet, error) {
 	return m, nil
 }
 
+// EnvironToEnvSetUnquoted behaves like EnvironToEnvSet, but a value wholly
+// wrapped in matching single or double quotes, as some launchers pass them,
+// has the outer quotes stripped, and within double quotes \" and \\ are
+// unescaped. Quotes inside a value are kept.
+func EnvironToEnvSetUnquoted(environ []string) (EnvSet, error) {
+	m, err := EnvironToEnvSet(environ)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range m {
+		m[k] = unquote(v)
+	}
+	return m, nil
+}
+
+// quoteUnescaper unescapes the escaped quotes and backslashes within a double
+// quoted value.
+var quoteUnescaper = strings.NewReplacer(`\\`, `\`, `\"`, `"`)
+
+// unquote strips the quotes wrapping value, if any; see
+// EnvironToEnvSetUnquoted.
+func unquote(value string) string {
+	if len(value) < 2 || value[0] != value[len(value)-1] {
+		return value
+	}
+
+	switch value[0] {
+	case '\'':
+		return value[1 : len(value)-1]
+	case '"':
+		return quoteUnescaper.Replace(value[1 : len(value)-1])
+	default:
+		return value
+	}
+}
+
 // EnvSetToEnviron transforms a EnvSet into a slice of strings with the format
 // "key=value".
 func EnvSetToEnviron(m EnvSet) []string {
@@ -68,3 +159,20 @@ func EnvSetToEnviron(m EnvSet) []string {
 	}
 	return environ
 }
+
+// Environ transforms es into a slice of strings with the format "key=value",
+// sorted by key, such as for exec.Cmd.Env. It is the inverse of
+// EnvironToEnvSet, but not of EnvironToEnvSetUnquoted for quoted values.
+func (es EnvSet) Environ() []string {
+	keys := make([]string, 0, len(es))
+	for k := range es {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	environ := make([]string, len(keys))
+	for i, k := range keys {
+		environ[i] = k + "=" + es[k]
+	}
+	return environ
+}
@@ -15,6 +15,7 @@ package env
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -84,6 +85,63 @@ func TestEnvironToEnvSetSplitN(t *testing.T) {
 	}
 }
 
+func TestEnvironToEnvSetKeepsQuotes(t *testing.T) {
+	environ := []string{`V="hello"`, `S='x'`}
+
+	m, err := EnvironToEnvSet(environ)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := EnvSet{"V": `"hello"`, "S": `'x'`}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", expected, m)
+	}
+
+	if !reflect.DeepEqual(m.Environ(), []string{`S='x'`, `V="hello"`}) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", []string{`S='x'`, `V="hello"`}, m.Environ())
+	}
+}
+
+func TestEnvironToEnvSetUnquoted(t *testing.T) {
+	environ := []string{
+		`PASSWORD="secret"`,
+		`TOKEN='abc def'`,
+		`DSN="a=b"`,
+		`ESCAPED="say \"hi\" C:\\tmp"`,
+		`SINGLE='it\'s'`,
+		`INNER=a "quoted" word`,
+		`MISMATCHED="open'`,
+		`QUOTE="`,
+		`EMPTY=""`,
+	}
+
+	m, err := EnvironToEnvSetUnquoted(environ)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	expected := EnvSet{
+		"PASSWORD":   "secret",
+		"TOKEN":      "abc def",
+		"DSN":        "a=b",
+		"ESCAPED":    `say "hi" C:\tmp`,
+		"SINGLE":     `it\'s`,
+		"INNER":      `a "quoted" word`,
+		"MISMATCHED": `"open'`,
+		"QUOTE":      `"`,
+		"EMPTY":      "",
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", expected, m)
+	}
+
+	_, err = EnvironToEnvSetUnquoted([]string{"INVALID"})
+	if err != ErrInvalidEnviron {
+		t.Errorf("Expected 'ErrInvalidEnviron' but got '%s'", err)
+	}
+}
+
 func TestEnvSetToEnviron(t *testing.T) {
 	m := EnvSet{
 		"HOME":      "/home/test",
@@ -110,3 +168,179 @@ func TestEnvSetToEnviron(t *testing.T) {
 		}
 	}
 }
+
+func TestEnvSetDiff(t *testing.T) {
+	es := EnvSet{
+		"HOME":      "/home/edgarl",
+		"WORKSPACE": "/mnt/builds/slave/workspace/test",
+		"SHELL":     "/bin/bash",
+	}
+
+	other := EnvSet{
+		"HOME":  "/home/edgarl",
+		"SHELL": "/bin/zsh",
+		"TERM":  "xterm",
+	}
+
+	added, removed, changed := es.Diff(other)
+
+	expected := map[string]string{"TERM": "xterm"}
+	if !reflect.DeepEqual(added, expected) {
+		t.Errorf("Expected added to be '%v' but got '%v'", expected, added)
+	}
+
+	expected = map[string]string{"WORKSPACE": "/mnt/builds/slave/workspace/test"}
+	if !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Expected removed to be '%v' but got '%v'", expected, removed)
+	}
+
+	expected = map[string]string{"SHELL": "/bin/zsh"}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Expected changed to be '%v' but got '%v'", expected, changed)
+	}
+
+	added, removed, changed = es.Diff(es)
+	if len(added) != 0 || len(removed) != 0 || len(changed) != 0 {
+		t.Errorf("Expected no differences but got '%v', '%v', '%v'", added, removed, changed)
+	}
+}
+
+func TestEnvSetEnviron(t *testing.T) {
+	es := EnvSet{
+		"WORKSPACE": "/mnt/builds/slave/workspace/test",
+		"HOME":      "/home/edgarl",
+		"QUERY":     "a=1&b=2",
+	}
+
+	environ := es.Environ()
+
+	expected := []string{
+		"HOME=/home/edgarl",
+		"QUERY=a=1&b=2",
+		"WORKSPACE=/mnt/builds/slave/workspace/test",
+	}
+	if !reflect.DeepEqual(environ, expected) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", expected, environ)
+	}
+
+	roundTrip, err := EnvironToEnvSet(environ)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if !reflect.DeepEqual(roundTrip, es) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", es, roundTrip)
+	}
+}
+
+func TestMergeEnvSets(t *testing.T) {
+	defaults := EnvSet{
+		"HOME":  "/home/default",
+		"SHELL": "/bin/sh",
+	}
+	file := EnvSet{
+		"HOME": "/home/file",
+		"TERM": "xterm",
+	}
+	environ := EnvSet{
+		"HOME": "/home/edgarl",
+	}
+
+	merged := MergeEnvSets(defaults, file, environ)
+
+	expected := EnvSet{
+		"HOME":  "/home/edgarl",
+		"SHELL": "/bin/sh",
+		"TERM":  "xterm",
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", expected, merged)
+	}
+
+	if defaults["HOME"] != "/home/default" || len(defaults) != 2 {
+		t.Errorf("Expected input to be unmodified but got '%v'", defaults)
+	}
+
+	merged = MergeEnvSets()
+	if merged == nil || len(merged) != 0 {
+		t.Errorf("Expected empty environ but got '%v'", merged)
+	}
+}
+
+func TestEnvSetMerge(t *testing.T) {
+	es := EnvSet{
+		"HOME":  "/home/default",
+		"SHELL": "/bin/sh",
+	}
+	other := EnvSet{
+		"HOME": "/home/edgarl",
+		"TERM": "xterm",
+	}
+
+	merged := es.Merge(other)
+
+	expected := EnvSet{
+		"HOME":  "/home/edgarl",
+		"SHELL": "/bin/sh",
+		"TERM":  "xterm",
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", expected, merged)
+	}
+
+	merged["SHELL"] = "/bin/bash"
+	if !reflect.DeepEqual(es, EnvSet{"HOME": "/home/default", "SHELL": "/bin/sh"}) {
+		t.Errorf("Expected input to be unmodified but got '%v'", es)
+	}
+	if !reflect.DeepEqual(other, EnvSet{"HOME": "/home/edgarl", "TERM": "xterm"}) {
+		t.Errorf("Expected input to be unmodified but got '%v'", other)
+	}
+
+	var empty EnvSet
+	merged = empty.Merge(nil)
+	if merged == nil || len(merged) != 0 {
+		t.Errorf("Expected empty environ but got '%v'", merged)
+	}
+}
+
+func TestEnvSetClone(t *testing.T) {
+	es := EnvSet{
+		"HOME": "/home/edgarl",
+		"PORT": "8080",
+	}
+
+	clone := es.Clone()
+	if !reflect.DeepEqual(clone, es) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", es, clone)
+	}
+
+	var portStruct struct {
+		Port int `env:"PORT"`
+	}
+	err := Unmarshal(clone, &portStruct)
+	if err != nil {
+		t.Errorf("Expected no error but got '%s'", err)
+	}
+
+	if portStruct.Port != 8080 {
+		t.Errorf("Expected field value to be '%d' but got '%d'", 8080, portStruct.Port)
+	}
+
+	expected := EnvSet{
+		"HOME": "/home/edgarl",
+		"PORT": "8080",
+	}
+	if !reflect.DeepEqual(es, expected) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", expected, es)
+	}
+
+	if !reflect.DeepEqual(clone, EnvSet{"HOME": "/home/edgarl"}) {
+		t.Errorf("Expected environ to be '%v' but got '%v'", EnvSet{"HOME": "/home/edgarl"}, clone)
+	}
+
+	var empty EnvSet
+	clone = empty.Clone()
+	if clone == nil || len(clone) != 0 {
+		t.Errorf("Expected empty environ but got '%v'", clone)
+	}
+}